@@ -8,6 +8,10 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(internal.RunReplay(os.Args[2:], envMap()))
+	}
+
 	os.Exit(internal.RunCLI(envMap()))
 }
 
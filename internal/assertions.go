@@ -1,29 +1,111 @@
 package internal
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	executable "github.com/codecrafters-io/tester-utils/executable"
 )
 
-func assertStdoutList(result executable.ExecutableResult, expected []string) error {
-	actual := string(result.Stdout)
-	if indexOf(expected, actual) == -1 {
-		return fmt.Errorf("Expected %q as stdout, got: %q", expected[0], actual)
+// assertStdoutJSON parses stdout as JSON and compares it to the expected value, so that whitespace and
+// escaping styles don't matter. Expected values are built from strings, ints, []interface{} and
+// map[string]interface{}.
+func assertStdoutJSON(result executable.ExecutableResult, expected interface{}) error {
+	expectedJSON, err := json.Marshal(expected)
+	if err != nil {
+		return err
+	}
+
+	if err := compareStdoutJSON(result.Stdout, expected); err != nil {
+		return fmt.Errorf("Expected %s as stdout, got: %q. %v", expectedJSON, string(result.Stdout), err)
 	}
 
 	return nil
 }
 
-func indexOf(slice []string, target string) int {
-	for i, v := range slice {
-		if v == target {
-			return i
+// compareStdoutJSON is like assertStdoutJSON, but leaves out the expected and actual output from the error
+func compareStdoutJSON(stdout []byte, expected interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(stdout))
+	decoder.UseNumber()
+
+	var actualValue interface{}
+	if err := decoder.Decode(&actualValue); err != nil {
+		return fmt.Errorf("Output isn't valid JSON (%v)", err)
+	}
+	if decoder.More() {
+		return fmt.Errorf("Output has unexpected data after the JSON value")
+	}
+
+	return compareJSON("$", expected, actualValue)
+}
+
+// compareJSON returns an error describing the first path where actual differs from expected
+func compareJSON(path string, expected interface{}, actual interface{}) error {
+	switch expected := expected.(type) {
+	case string:
+		if actualString, ok := actual.(string); !ok || actualString != expected {
+			return fmt.Errorf("At %s: expected %q, got %s", path, expected, describeJSON(actual))
+		}
+	case int:
+		// Compare the literal digits, float64 would lose precision for large integers
+		if actualNumber, ok := actual.(json.Number); !ok || actualNumber.String() != strconv.Itoa(expected) {
+			return fmt.Errorf("At %s: expected integer %d, got %s", path, expected, describeJSON(actual))
+		}
+	case []interface{}:
+		actualList, ok := actual.([]interface{})
+		if !ok {
+			return fmt.Errorf("At %s: expected a list, got %s", path, describeJSON(actual))
+		}
+		for i := range min(len(expected), len(actualList)) {
+			if err := compareJSON(fmt.Sprintf("%s[%d]", path, i), expected[i], actualList[i]); err != nil {
+				return err
+			}
 		}
+		if len(expected) != len(actualList) {
+			return fmt.Errorf("At %s: expected a list with %d elements, got %d elements", path, len(expected), len(actualList))
+		}
+	case map[string]interface{}:
+		actualDict, ok := actual.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("At %s: expected a dictionary, got %s", path, describeJSON(actual))
+		}
+		for _, key := range sortedKeys(expected) {
+			value, exists := actualDict[key]
+			if !exists {
+				return fmt.Errorf("At %s: expected key %q, but it's missing", path, key)
+			}
+			if err := compareJSON(fmt.Sprintf("%s.%s", path, key), expected[key], value); err != nil {
+				return err
+			}
+		}
+		for key := range actualDict {
+			if _, exists := expected[key]; !exists {
+				return fmt.Errorf("At %s: unexpected key %q", path, key)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported expected value type %T at %s", expected, path)
+	}
+
+	return nil
+}
+
+func describeJSON(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return fmt.Sprintf("string %q", value)
+	case json.Number:
+		return fmt.Sprintf("number %s", value)
+	case nil:
+		return "null"
+	default:
+		encoded, _ := json.Marshal(value)
+		return string(encoded)
 	}
-	return -1
 }
 
 func assertStdout(result executable.ExecutableResult, expected string) error {
@@ -74,6 +156,14 @@ func assertExitCode(result executable.ExecutableResult, expected int) error {
 	return nil
 }
 
+func assertNonZeroExitCode(result executable.ExecutableResult) error {
+	if result.ExitCode == 0 {
+		return fmt.Errorf("Expected a non-zero exit code for invalid input, got 0 with stdout: %q", string(result.Stdout))
+	}
+
+	return nil
+}
+
 func assertFileSize(downloadedFilePath string, expectedFileSize int64) error {
 	fileInfo, err := os.Stat(downloadedFilePath)
 	if err != nil {
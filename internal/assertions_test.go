@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	executable "github.com/codecrafters-io/tester-utils/executable"
+)
+
+func TestAssertStdoutJSON(t *testing.T) {
+	expected := map[string]interface{}{
+		"inner_dict": map[string]interface{}{
+			"key2":     4294967300,
+			"list_key": []interface{}{"item1", "é", -3},
+		},
+	}
+
+	for _, stdout := range []string{
+		"{\"inner_dict\":{\"key2\":4294967300,\"list_key\":[\"item1\",\"é\",-3]}}\n",
+		"{ \"inner_dict\": {\"list_key\": [\"item1\", \"\\u00e9\", -3], \"key2\": 4294967300} }",
+	} {
+		if err := assertStdoutJSON(executable.ExecutableResult{Stdout: []byte(stdout)}, expected); err != nil {
+			t.Errorf("expected %q to match, got: %v", stdout, err)
+		}
+	}
+
+	failures := map[string]string{
+		"{\"inner_dict\":{\"key2\":4294967300,\"list_key\":[\"item1\",\"é\",\"-3\"]}}": "At $.inner_dict.list_key[2]: expected integer -3, got string \"-3\"",
+		"{\"inner_dict\":{\"key2\":4.2949673e+09,\"list_key\":[\"item1\",\"é\",-3]}}":  "At $.inner_dict.key2: expected integer 4294967300, got number 4.2949673e+09",
+		"{\"inner_dict\":{\"key2\":4294967300,\"list_key\":[\"item1\",\"é\"]}}":        "At $.inner_dict.list_key: expected a list with 3 elements, got 2 elements",
+		"{\"inner_dict\":{\"list_key\":[\"item1\",\"é\",-3]}}":                         "At $.inner_dict: expected key \"key2\", but it's missing",
+		"{\"inner_dict\":{}} {}": "Output has unexpected data after the JSON value",
+	}
+	for stdout, message := range failures {
+		err := assertStdoutJSON(executable.ExecutableResult{Stdout: []byte(stdout)}, expected)
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("expected %q to fail with %q, got: %v", stdout, message, err)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// Reference bencode encoder and random value generator used to check the user's encoder and decoder
+package internal
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/codecrafters-io/tester-utils/random"
+)
+
+// encodeBencode returns the canonical encoding of a value built from strings, ints, []interface{} and
+// map[string]interface{}. Dictionary keys are sorted by their raw bytes.
+func encodeBencode(value interface{}) []byte {
+	var buffer bytes.Buffer
+	writeBencode(&buffer, value)
+	return buffer.Bytes()
+}
+
+func writeBencode(buffer *bytes.Buffer, value interface{}) {
+	switch value := value.(type) {
+	case string:
+		buffer.WriteString(strconv.Itoa(len(value)))
+		buffer.WriteByte(':')
+		buffer.WriteString(value)
+	case int:
+		buffer.WriteByte('i')
+		buffer.WriteString(strconv.Itoa(value))
+		buffer.WriteByte('e')
+	case []interface{}:
+		buffer.WriteByte('l')
+		for _, element := range value {
+			writeBencode(buffer, element)
+		}
+		buffer.WriteByte('e')
+	case map[string]interface{}:
+		buffer.WriteByte('d')
+		for _, key := range sortedKeys(value) {
+			writeBencode(buffer, key)
+			writeBencode(buffer, value[key])
+		}
+		buffer.WriteByte('e')
+	default:
+		panic(fmt.Sprintf("unsupported bencode value type %T", value))
+	}
+}
+
+func sortedKeys(dict map[string]interface{}) []string {
+	keys := make([]string, 0, len(dict))
+	for key := range dict {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// fromDecodedBencode converts values decoded by jackpal/bencode-go into the types used by encodeBencode
+func fromDecodedBencode(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case int64:
+		return int(value), nil
+	case []interface{}:
+		list := make([]interface{}, len(value))
+		for i, element := range value {
+			converted, err := fromDecodedBencode(element)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = converted
+		}
+		return list, nil
+	case map[string]interface{}:
+		dict := make(map[string]interface{}, len(value))
+		for key, element := range value {
+			converted, err := fromDecodedBencode(element)
+			if err != nil {
+				return nil, err
+			}
+			dict[key] = converted
+		}
+		return dict, nil
+	default:
+		return nil, fmt.Errorf("unexpected decoded value type %T", value)
+	}
+}
+
+// hexEncodeBinaryStrings replaces strings that aren't valid UTF-8 with their lowercase hex encoding,
+// which is how decode prints them
+func hexEncodeBinaryStrings(value interface{}) interface{} {
+	switch value := value.(type) {
+	case string:
+		if !utf8.ValidString(value) {
+			return hex.EncodeToString([]byte(value))
+		}
+		return value
+	case []interface{}:
+		list := make([]interface{}, len(value))
+		for i, element := range value {
+			list[i] = hexEncodeBinaryStrings(element)
+		}
+		return list
+	case map[string]interface{}:
+		dict := make(map[string]interface{}, len(value))
+		for key, element := range value {
+			dict[hexEncodeBinaryStrings(key).(string)] = hexEncodeBinaryStrings(element)
+		}
+		return dict
+	default:
+		return value
+	}
+}
+
+// randomBencodeValue generates a random value, nesting lists (and dictionaries if includeDicts is set) up
+// to maxDepth levels deep
+func randomBencodeValue(maxDepth int, includeDicts bool) interface{} {
+	kinds := 4
+	if !includeDicts {
+		kinds = 3
+	}
+	if maxDepth <= 0 {
+		kinds = 2
+	}
+
+	switch random.RandomInt(0, kinds) {
+	case 0:
+		return randomBencodeString()
+	case 1:
+		return randomBencodeInt()
+	case 2:
+		return randomBencodeList(maxDepth, includeDicts)
+	default:
+		return randomBencodeDict(maxDepth)
+	}
+}
+
+func randomBencodeList(maxDepth int, includeDicts bool) []interface{} {
+	list := make([]interface{}, random.RandomInt(0, 4))
+	for i := range list {
+		list[i] = randomBencodeValue(maxDepth-1, includeDicts)
+	}
+	return list
+}
+
+func randomBencodeDict(maxDepth int) map[string]interface{} {
+	dict := make(map[string]interface{})
+	for range random.RandomInt(0, 4) {
+		dict[randomBencodeString()] = randomBencodeValue(maxDepth-1, true)
+	}
+	return dict
+}
+
+func randomBencodeString() string {
+	switch random.RandomInt(0, 4) {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf("%s %s", random.RandomWord(), random.RandomWord())
+	default:
+		return random.RandomWord()
+	}
+}
+
+func randomBencodeInt() int {
+	switch random.RandomInt(0, 4) {
+	case 0:
+		return 0
+	case 1:
+		return -random.RandomInt(1, 1000000)
+	case 2:
+		// Larger than 32 bits
+		return random.RandomInt(1<<32, 1<<52)
+	default:
+		return random.RandomInt(1, 1000)
+	}
+}
@@ -0,0 +1,178 @@
+// Property-based checks of the user's decoder against values from the reference encoder
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/codecrafters-io/tester-utils/logger"
+)
+
+// maxShrinkRuns limits how many times the decoder is run while shrinking a failing value
+const maxShrinkRuns = 40
+
+// fuzzIterations is how many generated values are decoded after the seeds
+const fuzzIterations = 25
+
+type decodeFailureKind string
+
+const (
+	decodeFailedToRun   decodeFailureKind = "failed to run"
+	decodeWrongExitCode decodeFailureKind = "wrong exit code"
+	decodeWrongOutput   decodeFailureKind = "wrong output"
+)
+
+// decodeFailure tells apart the ways a decode can fail, so shrinking only keeps values that fail the same way
+type decodeFailure struct {
+	kind decodeFailureKind
+	err  error
+}
+
+func (f *decodeFailure) Error() string {
+	return f.err.Error()
+}
+
+func (f *decodeFailure) Unwrap() error {
+	return f.err
+}
+
+// failsTheSameWay reports whether both errors are decode failures of the same kind
+func failsTheSameWay(err error, other error) bool {
+	var failure, otherFailure *decodeFailure
+	return errors.As(err, &failure) && errors.As(other, &otherFailure) && failure.kind == otherFailure.kind
+}
+
+// fuzzDecode decodes every seed and then the given number of generated values, and shrinks the first
+// failing value to a minimal counterexample
+func fuzzDecode(executable *recordingExecutable, logger *logger.Logger, seeds []interface{}, generate func() interface{}, iterations int) error {
+	values := append([]interface{}{}, seeds...)
+	for range iterations {
+		values = append(values, generate())
+	}
+
+	for _, value := range values {
+		encoded := encodeBencode(value)
+		expected, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+
+		logger.Infof("Running ./%s decode %s", path.Base(executable.Path), encoded)
+		logger.Infof("Expected output: %s", expected)
+		if err := checkDecode(executable, value); err != nil {
+			return shrinkAndReport(executable, logger, value, err)
+		}
+	}
+
+	return nil
+}
+
+// checkDecode runs decode on the encoded value and returns an error if the output doesn't match
+func checkDecode(executable *recordingExecutable, value interface{}) error {
+	result, err := executable.Run("decode", string(encodeBencode(value)))
+	if err != nil {
+		return &decodeFailure{decodeFailedToRun, err}
+	}
+
+	if err = assertExitCode(result, 0); err != nil {
+		return &decodeFailure{decodeWrongExitCode, err}
+	}
+
+	if err = assertStdoutJSON(result, value); err != nil {
+		return &decodeFailure{decodeWrongOutput, err}
+	}
+	return nil
+}
+
+func shrinkAndReport(e *recordingExecutable, logger *logger.Logger, value interface{}, err error) error {
+	quiet := newQuietExecutable(e)
+	runs := 0
+
+	logger.Infoln("Looking for a smaller input that fails the same way...")
+	minimal, minimalErr := value, err
+	for shrunk := true; shrunk && runs < maxShrinkRuns; {
+		shrunk = false
+		for _, candidate := range shrinkCandidates(minimal) {
+			if runs >= maxShrinkRuns {
+				break
+			}
+			runs++
+			if candidateErr := checkDecode(quiet, candidate); candidateErr != nil && failsTheSameWay(candidateErr, minimalErr) {
+				minimal, minimalErr = candidate, candidateErr
+				shrunk = true
+				break
+			}
+		}
+	}
+
+	if string(encodeBencode(minimal)) == string(encodeBencode(value)) {
+		return err
+	}
+
+	logger.Infof("Minimal failing input: ./%s decode %s", path.Base(e.Path), encodeBencode(minimal))
+	return fmt.Errorf("Decoding %s failed: %v", encodeBencode(minimal), minimalErr)
+}
+
+// shrinkCandidates returns values that are strictly smaller than the given value, simplest first
+func shrinkCandidates(value interface{}) []interface{} {
+	var candidates []interface{}
+
+	switch value := value.(type) {
+	case string:
+		if value != "" {
+			candidates = append(candidates, "", value[:len(value)/2])
+		}
+	case int:
+		if value != 0 {
+			candidates = append(candidates, 0)
+		}
+		if value < 0 {
+			candidates = append(candidates, -value)
+		}
+		if value/2 != 0 {
+			candidates = append(candidates, value/2)
+		}
+	case []interface{}:
+		for _, element := range value {
+			candidates = append(candidates, element)
+		}
+		for i := range value {
+			candidates = append(candidates, append(append([]interface{}{}, value[:i]...), value[i+1:]...))
+		}
+		for i, element := range value {
+			for _, shrunk := range shrinkCandidates(element) {
+				list := append([]interface{}{}, value...)
+				list[i] = shrunk
+				candidates = append(candidates, list)
+			}
+		}
+	case map[string]interface{}:
+		for _, key := range sortedKeys(value) {
+			candidates = append(candidates, value[key])
+		}
+		for _, key := range sortedKeys(value) {
+			dict := copyDict(value)
+			delete(dict, key)
+			candidates = append(candidates, dict)
+		}
+		for _, key := range sortedKeys(value) {
+			for _, shrunk := range shrinkCandidates(value[key]) {
+				dict := copyDict(value)
+				dict[key] = shrunk
+				candidates = append(candidates, dict)
+			}
+		}
+	}
+
+	return candidates
+}
+
+func copyDict(dict map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(dict))
+	for key, value := range dict {
+		copied[key] = value
+	}
+	return copied
+}
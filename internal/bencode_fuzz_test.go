@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestShrinkCandidatesAreSmaller(t *testing.T) {
+	value := map[string]interface{}{
+		"foo": []interface{}{"hello", -52, []interface{}{}},
+		"bar": 7,
+	}
+
+	candidates := shrinkCandidates(value)
+	if !reflect.DeepEqual(candidates[0], 7) {
+		t.Fatalf("expected the first candidate to be the simplest child, got %#v", candidates[0])
+	}
+	for _, candidate := range candidates {
+		if len(encodeBencode(candidate)) > len(encodeBencode(value)) || reflect.DeepEqual(candidate, value) {
+			t.Errorf("candidate %q isn't smaller than %q", encodeBencode(candidate), encodeBencode(value))
+		}
+	}
+
+	// Shrinking has to terminate, every value eventually runs out of candidates
+	for depth, current := 0, interface{}(value); len(shrinkCandidates(current)) > 0; depth++ {
+		if depth > 100 {
+			t.Fatalf("shrinking didn't terminate")
+		}
+		current = shrinkCandidates(current)[len(shrinkCandidates(current))-1]
+	}
+}
+
+func TestShrinkingKeepsTheFailureKind(t *testing.T) {
+	wrongOutput := &decodeFailure{decodeWrongOutput, errors.New("Expected [] as stdout")}
+	if !failsTheSameWay(&decodeFailure{decodeWrongOutput, errors.New("Expected [1] as stdout")}, wrongOutput) {
+		t.Error("expected two wrong outputs to fail the same way")
+	}
+	if failsTheSameWay(&decodeFailure{decodeWrongExitCode, errors.New("Expected 0 as exit code")}, wrongOutput) {
+		t.Error("expected a wrong exit code to not fail the same way as a wrong output")
+	}
+}
@@ -0,0 +1,39 @@
+package internal
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/tester-utils/random"
+	"github.com/jackpal/bencode-go"
+)
+
+func TestEncodeBencodeRoundTrip(t *testing.T) {
+	random.Init()
+
+	for range 200 {
+		value := randomBencodeValue(4, true)
+		encoded := encodeBencode(value)
+
+		decoded, err := bencode.Decode(bytes.NewReader(encoded))
+		if err != nil {
+			t.Fatalf("error decoding %q: %v", encoded, err)
+		}
+		converted, err := fromDecodedBencode(decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(converted, value) {
+			t.Fatalf("expected %q to decode to %#v, got %#v", encoded, value, converted)
+		}
+
+		var marshaled bytes.Buffer
+		if err := bencode.Marshal(&marshaled, value); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(marshaled.Bytes(), encoded) {
+			t.Fatalf("expected canonical encoding %q, got %q", marshaled.Bytes(), encoded)
+		}
+	}
+}
@@ -22,8 +22,12 @@ type Handshake struct {
 }
 
 type TorrentFile struct {
-	Announce string          `bencode:"announce"`
-	Info     TorrentFileInfo `bencode:"info"`
+	Announce     string          `bencode:"announce"`
+	Info         TorrentFileInfo `bencode:"info"`
+	URLList      []string        `bencode:"url-list,omitempty"`
+	Comment      string          `bencode:"comment,omitempty"`
+	CreatedBy    string          `bencode:"created by,omitempty"`
+	CreationDate int             `bencode:"creation date,omitempty"`
 }
 
 type TorrentFileInfo struct {
@@ -111,6 +115,15 @@ func createPiecesStrFromFile(filePath string, pieceLengthBytes int) (string, err
 	return strings.Join(pieceHashes, ""), nil
 }
 
+func createPiecesStrFromBytes(content []byte, pieceLengthBytes int) string {
+	var pieces strings.Builder
+	for start := 0; start < len(content); start += pieceLengthBytes {
+		hash := sha1.Sum(content[start:min(start+pieceLengthBytes, len(content))])
+		pieces.Write(hash[:])
+	}
+	return pieces.String()
+}
+
 func readHandshake(r io.Reader, logger *logger.Logger) (*Handshake, error) {
 	logger.Debugln("Waiting to receive handshake message")
 	// Handshake message contents:
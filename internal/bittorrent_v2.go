@@ -0,0 +1,228 @@
+// BitTorrent v2 (BEP 52) torrent files and SHA-256 merkle trees
+package internal
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/bits"
+	"os"
+	"strings"
+
+	"github.com/jackpal/bencode-go"
+)
+
+const v2BlockSize = 16 * 1024
+
+// torrentVersion tells how the info hash of a torrent is computed
+type torrentVersion int
+
+const (
+	torrentVersionV1 torrentVersion = iota
+	torrentVersionV2
+	// Hybrid torrents are announced with their v1 info hash
+	torrentVersionHybrid
+)
+
+// infoHashDescription explains how the 20 byte info hash sent to trackers and peers is computed
+func (v torrentVersion) infoHashDescription() string {
+	switch v {
+	case torrentVersionV2:
+		return "SHA-256 of the bencoded info dictionary from the torrent file, truncated to 20 bytes"
+	case torrentVersionHybrid:
+		return "SHA-1 of the bencoded info dictionary from the torrent file, hybrid torrents use the v1 info hash"
+	default:
+		return "SHA-1 of the bencoded info dictionary from the torrent file"
+	}
+}
+
+type TorrentFileV2 struct {
+	Announce    string            `bencode:"announce"`
+	Info        TorrentFileInfoV2 `bencode:"info"`
+	PieceLayers map[string]string `bencode:"piece layers,omitempty"`
+}
+
+type TorrentFileInfoV2 struct {
+	Name        string                 `bencode:"name"`
+	MetaVersion int                    `bencode:"meta version"`
+	PieceLength int                    `bencode:"piece length"`
+	FileTree    map[string]interface{} `bencode:"file tree"`
+	// Only present in hybrid torrents, which are also valid v1 torrents
+	Length int    `bencode:"length,omitempty"`
+	Pieces string `bencode:"pieces,omitempty"`
+}
+
+type TorrentFileV2File struct {
+	Path    []string
+	Content []byte
+}
+
+func (i *TorrentFileInfoV2) encode() ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := bencode.Marshal(&buffer, *i); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (i *TorrentFileInfoV2) hash() ([32]byte, error) {
+	encoded, err := i.encode()
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return sha256.Sum256(encoded), nil
+}
+
+// hashV1 returns the v1 info hash of a hybrid torrent
+func (i *TorrentFileInfoV2) hashV1() ([20]byte, error) {
+	encoded, err := i.encode()
+	if err != nil {
+		return [20]byte{}, err
+	}
+	return sha1.Sum(encoded), nil
+}
+
+func (torrent *TorrentFileV2) writeToFile(outputPath string) ([32]byte, error) {
+	torrentFile, err := os.Create(outputPath)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	defer torrentFile.Close()
+
+	err = bencode.Marshal(torrentFile, *torrent)
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	return torrent.Info.hash()
+}
+
+// truncatedInfoHash returns the 20 byte form of a v2 info hash used in handshakes and tracker announces
+func truncatedInfoHash(infoHash [32]byte) [20]byte {
+	var truncated [20]byte
+	copy(truncated[:], infoHash[:20])
+	return truncated
+}
+
+// newTorrentFileV2 creates a v2 torrent along with the merkle tree of every file, keyed by pieces root
+func newTorrentFileV2(announce string, name string, pieceLength int, files []TorrentFileV2File) (*TorrentFileV2, map[[32]byte]*merkleTree, error) {
+	torrent := TorrentFileV2{
+		Announce: announce,
+		Info: TorrentFileInfoV2{
+			Name:        name,
+			MetaVersion: 2,
+			PieceLength: pieceLength,
+			FileTree:    make(map[string]interface{}),
+		},
+		PieceLayers: make(map[string]string),
+	}
+	trees := make(map[[32]byte]*merkleTree)
+
+	for _, file := range files {
+		if len(file.Content) == 0 {
+			return nil, nil, errors.New("empty files are not supported")
+		}
+
+		tree := newMerkleTree(file.Content)
+		root := tree.root()
+		trees[root] = tree
+
+		directory := torrent.Info.FileTree
+		for _, component := range file.Path[:len(file.Path)-1] {
+			child, exists := directory[component]
+			if !exists {
+				child = make(map[string]interface{})
+				directory[component] = child
+			}
+			directory = child.(map[string]interface{})
+		}
+		directory[file.Path[len(file.Path)-1]] = map[string]interface{}{
+			"": map[string]interface{}{
+				"length":      len(file.Content),
+				"pieces root": string(root[:]),
+			},
+		}
+
+		// Files that fit in a single piece don't have a piece layer
+		if len(file.Content) > pieceLength {
+			var layer strings.Builder
+			for _, hash := range tree.pieceLayer(pieceLength, len(file.Content)) {
+				layer.Write(hash[:])
+			}
+			torrent.PieceLayers[string(root[:])] = layer.String()
+		}
+	}
+
+	return &torrent, trees, nil
+}
+
+// merkleTree holds every layer of a BEP 52 merkle tree, starting with the 16 KiB block hashes
+type merkleTree struct {
+	layers [][][32]byte
+}
+
+func newMerkleTree(content []byte) *merkleTree {
+	blockCount := (len(content) + v2BlockSize - 1) / v2BlockSize
+	leafCount := 1 << bits.Len(uint(blockCount-1))
+
+	// Leaves beyond the end of the file are zero hashes, not hashes of zeros
+	leaves := make([][32]byte, max(leafCount, 1))
+	for i := 0; i < blockCount; i++ {
+		leaves[i] = sha256.Sum256(content[i*v2BlockSize : min((i+1)*v2BlockSize, len(content))])
+	}
+
+	tree := merkleTree{layers: [][][32]byte{leaves}}
+	for layer := leaves; len(layer) > 1; {
+		parent := make([][32]byte, len(layer)/2)
+		for i := range parent {
+			parent[i] = sha256.Sum256(append(layer[2*i][:], layer[2*i+1][:]...))
+		}
+		tree.layers = append(tree.layers, parent)
+		layer = parent
+	}
+	return &tree
+}
+
+func (t *merkleTree) root() [32]byte {
+	return t.layers[len(t.layers)-1][0]
+}
+
+func pieceLayerIndex(pieceLength int) int {
+	return bits.Len(uint(pieceLength/v2BlockSize)) - 1
+}
+
+// pieceLayer returns the hashes of every piece of a file, excluding padding beyond the end of the file
+func (t *merkleTree) pieceLayer(pieceLength int, fileLength int) [][32]byte {
+	pieceCount := (fileLength + pieceLength - 1) / pieceLength
+	return t.layers[pieceLayerIndex(pieceLength)][:pieceCount]
+}
+
+// proof returns the requested hashes of a layer followed by the uncle hashes needed to verify them
+func (t *merkleTree) proof(baseLayer int, index int, length int, proofLayers int) ([][32]byte, error) {
+	if baseLayer < 0 || baseLayer >= len(t.layers) {
+		return nil, fmt.Errorf("base layer %d doesn't exist, the tree has %d layers", baseLayer, len(t.layers))
+	}
+	if length < 1 || length&(length-1) != 0 {
+		return nil, fmt.Errorf("length needs to be a power of two, got %d", length)
+	}
+	if index < 0 || index%length != 0 {
+		return nil, fmt.Errorf("index needs to be a multiple of length (%d), got %d", length, index)
+	}
+	layer := t.layers[baseLayer]
+	if index+length > len(layer) {
+		return nil, fmt.Errorf("requested hashes %d-%d but layer %d only has %d hashes", index, index+length-1, baseLayer, len(layer))
+	}
+
+	hashes := append([][32]byte{}, layer[index:index+length]...)
+
+	subtreeLayer := baseLayer + bits.Len(uint(length)) - 1
+	subtreeIndex := index / length
+	for l := subtreeLayer; l < len(t.layers)-1 && proofLayers > 0; l++ {
+		hashes = append(hashes, t.layers[l][subtreeIndex^1])
+		subtreeIndex /= 2
+		proofLayers--
+	}
+	return hashes, nil
+}
@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestMerkleTreeProofVerifiesAgainstRoot(t *testing.T) {
+	pieceLength := 2 * v2BlockSize
+	content := bytes.Repeat([]byte("abcdefgh"), (5*pieceLength+100)/8)
+	tree := newMerkleTree(content)
+
+	pieceLayer := tree.pieceLayer(pieceLength, len(content))
+	if len(pieceLayer) != 6 {
+		t.Fatalf("expected 6 piece hashes, got %d", len(pieceLayer))
+	}
+
+	// Each piece hash is the root of the merkle tree of its blocks
+	secondPiece := newMerkleTree(content[pieceLength : 2*pieceLength])
+	if secondPiece.root() != pieceLayer[1] {
+		t.Fatalf("piece hash doesn't match the merkle root of the piece")
+	}
+
+	hashes, err := tree.proof(pieceLayerIndex(pieceLength), 0, 8, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 8 piece layer hashes make up the whole tree, so no uncle hashes are needed
+	if len(hashes) != 8 {
+		t.Fatalf("expected 8 hashes, got %d", len(hashes))
+	}
+	layer := hashes
+	for len(layer) > 1 {
+		var parent [][32]byte
+		for i := 0; i < len(layer); i += 2 {
+			parent = append(parent, sha256.Sum256(append(layer[i][:], layer[i+1][:]...)))
+		}
+		layer = parent
+	}
+	if layer[0] != tree.root() {
+		t.Fatalf("piece layer doesn't hash up to the pieces root")
+	}
+
+	hashes, err = tree.proof(pieceLayerIndex(pieceLength), 2, 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 4 {
+		t.Fatalf("expected 2 hashes and 2 uncle hashes, got %d", len(hashes))
+	}
+	node := sha256.Sum256(append(hashes[0][:], hashes[1][:]...))
+	node = sha256.Sum256(append(hashes[2][:], node[:]...))
+	node = sha256.Sum256(append(node[:], hashes[3][:]...))
+	if node != tree.root() {
+		t.Fatalf("uncle hashes don't verify against the pieces root")
+	}
+
+	if _, err := tree.proof(pieceLayerIndex(pieceLength), 1, 2, 0); err == nil {
+		t.Fatalf("expected misaligned index to be rejected")
+	}
+}
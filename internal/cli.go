@@ -1,9 +1,51 @@
 package internal
 
 import (
+	"fmt"
+	"os"
+
 	testerutils "github.com/codecrafters-io/tester-utils"
 )
 
 func RunCLI(env map[string]string) int {
-	return testerutils.RunCLI(env, testerDefinition)
+	var report *testReport
+	if reportPath := env[reportPathEnvVar]; reportPath != "" {
+		report = newTestReport(reportPath)
+	}
+
+	if env[failureBundleDirectoryEnvVar] != "" {
+		pinRandomSeed(env)
+	}
+
+	metrics, err := newMetricsRecorder(env)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	parallelism, err := parallelismFrom(env)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	var exitCode int
+	if parallelism > 1 {
+		exitCode = runInParallel(env, parallelism, report, metrics, os.Stdout)
+	} else {
+		definition := testerDefinition
+		definition.TestCases = withTestCaseContexts(testerDefinition.TestCases, env, report, metrics)
+		exitCode = testerutils.RunCLI(env, definition)
+	}
+
+	if err := metrics.write(); err != nil {
+		fmt.Printf("Error writing metrics: %v\n", err)
+	}
+
+	if report != nil {
+		if err := report.write(); err != nil {
+			fmt.Printf("Error writing test report: %v\n", err)
+		}
+	}
+	return exitCode
 }
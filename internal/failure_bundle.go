@@ -0,0 +1,221 @@
+// Self-contained bundles of failed stages, and replaying them against another executable
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	logger "github.com/codecrafters-io/tester-utils/logger"
+)
+
+// failureBundleDirectoryEnvVar names the directory that receives a bundle for every failed stage
+const failureBundleDirectoryEnvVar = "CODECRAFTERS_FAILURE_BUNDLE_DIRECTORY"
+
+const randomSeedEnvVar = "CODECRAFTERS_RANDOM_SEED"
+
+// Files passed to the user's program are copied into the bundle unless they're larger than this
+const maxBundledFileSize = 10 * 1024 * 1024
+
+type failureBundle struct {
+	Slug             string            `json:"slug"`
+	Error            string            `json:"error"`
+	RandomSeed       string            `json:"random_seed"`
+	TestCasesJSON    string            `json:"test_cases_json"`
+	Commands         []commandReport   `json:"commands"`
+	TrackerExchanges []trackerExchange `json:"tracker_exchanges"`
+	// Maps the paths passed to the user's program to their copies in the bundle
+	Files map[string]string `json:"files"`
+	// Magnet links passed to the user's program
+	MagnetLinks []string `json:"magnet_links,omitempty"`
+}
+
+type trackerExchange struct {
+	Request string `json:"request"`
+	Status  int    `json:"status"`
+	// Go quoted, compact peer lists are binary
+	Response string `json:"response"`
+}
+
+// trackerResponseRecorder keeps a copy of the response sent to the user's client
+type trackerResponseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *trackerResponseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *trackerResponseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// recordTrackerResponses wraps a tracker handler so its responses end up in the test case's failure bundle
+func recordTrackerResponses(logger *logger.Logger, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		testCase := testCaseContextFor(logger)
+		if testCase == nil {
+			handler(w, r)
+			return
+		}
+
+		recorder := &trackerResponseRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler(recorder, r)
+		testCase.addTrackerExchange(trackerExchange{
+			Request:  r.Method + " " + r.URL.String(),
+			Status:   recorder.status,
+			Response: fmt.Sprintf("%q", recorder.body.Bytes()),
+		})
+	}
+}
+
+// pinRandomSeed makes sure the run uses a known seed, so failed stages can be replayed
+func pinRandomSeed(env map[string]string) {
+	if env[randomSeedEnvVar] != "" {
+		return
+	}
+	setRandomSeed(env, strconv.FormatInt(time.Now().UnixNano()%1_000_000_000, 10))
+}
+
+// setRandomSeed sets the seed in both env and the process environment, which is where random.Init reads it from
+func setRandomSeed(env map[string]string, seed string) {
+	env[randomSeedEnvVar] = seed
+	os.Setenv(randomSeedEnvVar, seed)
+}
+
+func writeFailureBundle(directory string, testCase *testCaseContext, err error, env map[string]string, logger *logger.Logger) {
+	bundlePath := filepath.Join(directory, fmt.Sprintf("%s-%s", testCase.slug, env[randomSeedEnvVar]))
+	if writeErr := writeFailureBundleFiles(bundlePath, testCase, err, env); writeErr != nil {
+		logger.Errorf("Error writing failure bundle: %v", writeErr)
+		return
+	}
+	logger.Infof("Wrote failure bundle to %s. Replay it with: tester replay %s <path to your repository>", bundlePath, bundlePath)
+}
+
+func writeFailureBundleFiles(bundlePath string, testCase *testCaseContext, err error, env map[string]string) error {
+	testCase.mu.Lock()
+	defer testCase.mu.Unlock()
+
+	testCasesJSON, jsonErr := testCasesUntil(env["CODECRAFTERS_TEST_CASES_JSON"], testCase.slug)
+	if jsonErr != nil {
+		return jsonErr
+	}
+
+	if err := os.MkdirAll(filepath.Join(bundlePath, "files"), 0755); err != nil {
+		return err
+	}
+
+	bundle := failureBundle{
+		Slug:             testCase.slug,
+		Error:            err.Error(),
+		RandomSeed:       env[randomSeedEnvVar],
+		TestCasesJSON:    testCasesJSON,
+		Commands:         append([]commandReport{}, testCase.commands...),
+		TrackerExchanges: append([]trackerExchange{}, testCase.trackerExchanges...),
+		Files:            make(map[string]string),
+	}
+
+	for _, command := range testCase.commands {
+		for _, arg := range command.Args {
+			if strings.HasPrefix(arg, "magnet:") {
+				bundle.MagnetLinks = append(bundle.MagnetLinks, arg)
+				continue
+			}
+			if _, copied := bundle.Files[arg]; copied || !filepath.IsAbs(arg) {
+				continue
+			}
+			info, statErr := os.Stat(arg)
+			if statErr != nil || !info.Mode().IsRegular() || info.Size() > maxBundledFileSize {
+				continue
+			}
+			bundledPath := filepath.Join("files", fmt.Sprintf("%d-%s", len(bundle.Files)+1, filepath.Base(arg)))
+			if err := copyFile(arg, filepath.Join(bundlePath, bundledPath)); err != nil {
+				return err
+			}
+			bundle.Files[arg] = bundledPath
+		}
+	}
+
+	var transcripts strings.Builder
+	for i, transcript := range testCase.transcripts {
+		fmt.Fprintf(&transcripts, "Connection %d:\n%s\n\n", i+1, strings.Join(transcript.lines(), "\n"))
+	}
+	if err := os.WriteFile(filepath.Join(bundlePath, "transcripts.txt"), []byte(transcripts.String()), 0644); err != nil {
+		return err
+	}
+
+	encoded, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(bundlePath, "bundle.json"), append(encoded, '\n'), 0644)
+}
+
+// testCasesUntil drops the test cases after the failed one, the earlier ones are needed to consume the same
+// random numbers
+func testCasesUntil(testCasesJSON string, slug string) (string, error) {
+	var testCases []map[string]interface{}
+	if err := json.Unmarshal([]byte(testCasesJSON), &testCases); err != nil {
+		return "", fmt.Errorf("failed to parse CODECRAFTERS_TEST_CASES_JSON: %v", err)
+	}
+
+	for i, testCase := range testCases {
+		if testCase["slug"] == slug {
+			testCases = testCases[:i+1]
+			break
+		}
+	}
+
+	encoded, err := json.Marshal(testCases)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// RunReplay runs the stages of a failure bundle against another repository with the same random seed, which
+// generates the same torrents, magnet links and tracker responses
+func RunReplay(args []string, env map[string]string) int {
+	if len(args) != 2 {
+		fmt.Println("Usage: tester replay <bundle directory> <repository directory or executable>")
+		return 1
+	}
+
+	contents, err := os.ReadFile(filepath.Join(args[0], "bundle.json"))
+	if err != nil {
+		fmt.Printf("Error reading failure bundle: %v\n", err)
+		return 1
+	}
+	var bundle failureBundle
+	if err := json.Unmarshal(contents, &bundle); err != nil {
+		fmt.Printf("Error parsing failure bundle: %v\n", err)
+		return 1
+	}
+
+	repositoryDir := args[1]
+	if info, err := os.Stat(repositoryDir); err == nil && !info.IsDir() {
+		repositoryDir = filepath.Dir(repositoryDir)
+	}
+	absoluteRepositoryDir, err := filepath.Abs(repositoryDir)
+	if err != nil {
+		fmt.Printf("Error resolving repository directory: %v\n", err)
+		return 1
+	}
+
+	env["CODECRAFTERS_REPOSITORY_DIR"] = absoluteRepositoryDir
+	env["CODECRAFTERS_TEST_CASES_JSON"] = bundle.TestCasesJSON
+	setRandomSeed(env, bundle.RandomSeed)
+
+	fmt.Printf("Replaying %s with %s=%s\n\n", bundle.Slug, randomSeedEnvVar, bundle.RandomSeed)
+	return RunCLI(env)
+}
@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTestCasesUntil(t *testing.T) {
+	testCasesJSON := `[{"slug":"ns2","title":"a"},{"slug":"eb4","title":"b"},{"slug":"ah1","title":"c"}]`
+
+	truncated, err := testCasesUntil(testCasesJSON, "eb4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `[{"slug":"ns2","title":"a"},{"slug":"eb4","title":"b"}]`; truncated != expected {
+		t.Errorf("expected %s, got %s", expected, truncated)
+	}
+}
+
+func TestWriteFailureBundleFiles(t *testing.T) {
+	directory := t.TempDir()
+	torrentPath := filepath.Join(directory, "sample.torrent")
+	if err := os.WriteFile(torrentPath, []byte("d8:announce3:urle"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	magnetLink := "magnet:?xt=urn:btih:0102030405060708090a0b0c0d0e0f1011121314"
+
+	context := &testCaseContext{slug: "ca4"}
+	context.addCommand(commandReport{Args: []string{"./your_program.sh", "magnet_parse", magnetLink}, ExitCode: 0})
+	context.addCommand(commandReport{Args: []string{"./your_program.sh", "handshake", torrentPath, "127.0.0.1:6881"}, ExitCode: 1})
+	context.addTrackerExchange(trackerExchange{Request: "GET /announce", Status: 200, Response: `"d5:peers0:e"`})
+
+	env := map[string]string{
+		randomSeedEnvVar:               "42",
+		"CODECRAFTERS_TEST_CASES_JSON": `[{"slug":"ca4"},{"slug":"nd2"}]`,
+	}
+	bundlePath := filepath.Join(directory, "bundle")
+	if err := writeFailureBundleFiles(bundlePath, context, errors.New("handshake failed"), env); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := os.ReadFile(filepath.Join(bundlePath, "bundle.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bundle failureBundle
+	if err := json.Unmarshal(contents, &bundle); err != nil {
+		t.Fatal(err)
+	}
+
+	if bundle.RandomSeed != "42" || bundle.Error != "handshake failed" || bundle.TestCasesJSON != `[{"slug":"ca4"}]` {
+		t.Errorf("unexpected bundle: %s", contents)
+	}
+	if len(bundle.Commands) != 2 || len(bundle.TrackerExchanges) != 1 {
+		t.Errorf("expected 2 commands and 1 tracker exchange, got %s", contents)
+	}
+
+	bundledTorrent, err := os.ReadFile(filepath.Join(bundlePath, bundle.Files[torrentPath]))
+	if err != nil {
+		t.Fatalf("expected the torrent to be copied into the bundle: %v", err)
+	}
+	if string(bundledTorrent) != "d8:announce3:urle" {
+		t.Errorf("unexpected bundled torrent: %q", bundledTorrent)
+	}
+	if len(bundle.MagnetLinks) != 1 || bundle.MagnetLinks[0] != magnetLink {
+		t.Errorf("expected the magnet link in the bundle, got %v", bundle.MagnetLinks)
+	}
+}
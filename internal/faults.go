@@ -0,0 +1,218 @@
+// Network faults injected between the emulated peers and trackers and the user's client
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	logger "github.com/codecrafters-io/tester-utils/logger"
+	"github.com/codecrafters-io/tester-utils/random"
+)
+
+// faultProfileEnvVar names a fault profile used by every peer and tracker of stages that don't pick one
+const faultProfileEnvVar = "CODECRAFTERS_FAULT_PROFILE"
+
+var errResetByFaultProfile = errors.New("connection reset by fault profile")
+
+// faultProfile describes the network conditions that a peer or tracker runs under. Zero values disable a fault.
+type faultProfile struct {
+	// latency is added before every write
+	latency time.Duration
+	// bytesPerSecond limits how fast data is written
+	bytesPerSecond int
+	// segmentSize splits writes into segments of at most this many bytes, so the client sees short reads
+	segmentSize int
+	// maxRandomSegmentSize splits writes into segments of random sizes between 1 and this many bytes
+	maxRandomSegmentSize int
+	// segmentDelay is added between segments, so they aren't coalesced again before the client reads them
+	segmentDelay time.Duration
+	// keepFirstWrite sends the first write as one segment, so a handshake and bitfield written together arrive together
+	keepFirstWrite bool
+	// resetAfterBytes resets the connection once this many bytes were written
+	resetAfterBytes int
+	// acceptDelay is how long an accepted connection waits before its first read or write, other connections are
+	// accepted in the meantime
+	acceptDelay time.Duration
+}
+
+var faultProfiles = map[string]faultProfile{
+	"latency":                         {latency: 200 * time.Millisecond},
+	"low-bandwidth":                   {bytesPerSecond: 64 * 1024, segmentSize: 4 * 1024},
+	"one-byte-segments":               {segmentSize: 1},
+	"random-segments":                 {maxRandomSegmentSize: 1024, segmentDelay: time.Millisecond},
+	"random-segments-after-handshake": {maxRandomSegmentSize: 1024, segmentDelay: time.Millisecond, keepFirstWrite: true},
+	"reset-after-handshake":           {resetAfterBytes: 68},
+	"slow-accept":                     {acceptDelay: time.Second},
+}
+
+func faultProfileNamed(name string) (faultProfile, error) {
+	profile, exists := faultProfiles[name]
+	if !exists {
+		var names []string
+		for name := range faultProfiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return faultProfile{}, fmt.Errorf("unknown fault profile %q, available profiles: %s", name, strings.Join(names, ", "))
+	}
+	return profile, nil
+}
+
+// withFaults applies the named fault profile to every connection accepted by the listener. Without a name, the
+// profile from the environment is used, if any.
+func withFaults(listener net.Listener, name string, logger *logger.Logger) (net.Listener, error) {
+	if name == "" {
+		if testCase := testCaseContextFor(logger); testCase != nil {
+			name = testCase.faultProfile
+		}
+	}
+	if name == "" {
+		return listener, nil
+	}
+
+	profile, err := faultProfileNamed(name)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("Using fault profile %q on %s", name, listener.Addr())
+	// Seeded from the stage's random numbers, so replays split writes the same way
+	seeds := rand.New(rand.NewSource(int64(random.RandomInt(0, 1_000_000_000))))
+	return &faultyListener{Listener: listener, name: name, profile: profile, seeds: seeds, logger: logger}, nil
+}
+
+type faultyListener struct {
+	net.Listener
+	name    string
+	profile faultProfile
+	seeds   *rand.Rand
+	logger  *logger.Logger
+}
+
+func (l *faultyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return newFaultyConn(conn, l.name, l.profile, l.seeds.Int63(), l.logger), nil
+}
+
+type faultyConn struct {
+	net.Conn
+	name    string
+	profile faultProfile
+	logger  *logger.Logger
+
+	acceptedAt      time.Time
+	waitedForAccept sync.Once
+	mu              sync.Mutex
+	random          *rand.Rand
+	writes          int
+	written         int
+	reset           bool
+}
+
+// newFaultyConn applies a fault profile to a single connection, seed picks the sizes of random segments
+func newFaultyConn(conn net.Conn, name string, profile faultProfile, seed int64, logger *logger.Logger) *faultyConn {
+	return &faultyConn{Conn: conn, name: name, profile: profile, logger: logger, acceptedAt: time.Now(), random: rand.New(rand.NewSource(seed))}
+}
+
+// waitForAcceptDelay holds the first read or write until the accept delay has passed since the connection was
+// accepted
+func (c *faultyConn) waitForAcceptDelay() {
+	c.waitedForAccept.Do(func() {
+		if c.profile.acceptDelay > 0 {
+			c.logger.Debugf("Fault profile %q: waiting %s before handling the connection", c.name, c.profile.acceptDelay)
+			time.Sleep(time.Until(c.acceptedAt.Add(c.profile.acceptDelay)))
+		}
+	})
+}
+
+func (c *faultyConn) Read(b []byte) (int, error) {
+	c.waitForAcceptDelay()
+	return c.Conn.Read(b)
+}
+
+func (c *faultyConn) protocolViolation() error {
+	return protocolViolationOf(c.Conn)
+}
+
+func (c *faultyConn) Write(b []byte) (int, error) {
+	c.waitForAcceptDelay()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.reset {
+		return 0, errResetByFaultProfile
+	}
+	if c.profile.latency > 0 {
+		time.Sleep(c.profile.latency)
+	}
+
+	isSplit := !c.profile.keepFirstWrite || c.writes > 0
+	c.writes++
+
+	written := 0
+	for written < len(b) {
+		segment := b[written:]
+		if c.profile.segmentSize > 0 && isSplit {
+			segment = segment[:min(len(segment), c.profile.segmentSize)]
+		}
+		if c.profile.maxRandomSegmentSize > 0 && isSplit {
+			segment = segment[:min(len(segment), 1+c.random.Intn(c.profile.maxRandomSegmentSize))]
+		}
+		if c.profile.resetAfterBytes > 0 {
+			segment = segment[:min(len(segment), c.profile.resetAfterBytes-c.written)]
+		}
+		if c.profile.bytesPerSecond > 0 {
+			time.Sleep(time.Duration(len(segment)) * time.Second / time.Duration(c.profile.bytesPerSecond))
+		}
+
+		if c.profile.segmentDelay > 0 && written > 0 {
+			time.Sleep(c.profile.segmentDelay)
+		}
+
+		n, err := c.Conn.Write(segment)
+		written += n
+		c.written += n
+		if err != nil {
+			return written, err
+		}
+
+		if c.profile.resetAfterBytes > 0 && c.written >= c.profile.resetAfterBytes {
+			c.resetConnection()
+			if written < len(b) {
+				return written, errResetByFaultProfile
+			}
+		}
+	}
+	return written, nil
+}
+
+// resetConnection closes the connection with a RST instead of a FIN
+func (c *faultyConn) resetConnection() {
+	c.logger.Debugf("Fault profile %q: resetting connection after %d bytes", c.name, c.written)
+	c.reset = true
+
+	if tcpConn := underlyingTCPConn(c.Conn); tcpConn != nil {
+		tcpConn.SetLinger(0)
+	}
+	c.Conn.Close()
+}
+
+func underlyingTCPConn(conn net.Conn) *net.TCPConn {
+	switch c := conn.(type) {
+	case *net.TCPConn:
+		return c
+	case *capturedConn:
+		return underlyingTCPConn(c.Conn)
+	}
+	return nil
+}
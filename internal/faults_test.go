@@ -0,0 +1,141 @@
+package internal
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/tester-utils/logger"
+)
+
+// writeRecordingConn remembers the size of every write
+type writeRecordingConn struct {
+	net.Conn
+	writes []int
+	closed bool
+}
+
+func (c *writeRecordingConn) Write(b []byte) (int, error) {
+	c.writes = append(c.writes, len(b))
+	return len(b), nil
+}
+
+func (c *writeRecordingConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestFaultyConnSplitsWrites(t *testing.T) {
+	conn := &writeRecordingConn{}
+	faulty := &faultyConn{Conn: conn, name: "test", profile: faultProfile{segmentSize: 3}, logger: logger.GetQuietLogger("")}
+
+	n, err := faulty.Write([]byte("abcdefgh"))
+	if err != nil || n != 8 {
+		t.Fatalf("expected to write 8 bytes, wrote %d: %v", n, err)
+	}
+	if expected := []int{3, 3, 2}; !slices.Equal(conn.writes, expected) {
+		t.Errorf("expected writes of %v bytes, got %v", expected, conn.writes)
+	}
+}
+
+func TestFaultyConnKeepsFirstWrite(t *testing.T) {
+	conn := &writeRecordingConn{}
+	faulty := &faultyConn{Conn: conn, name: "test", profile: faultProfile{segmentSize: 3, keepFirstWrite: true}, logger: logger.GetQuietLogger("")}
+
+	faulty.Write([]byte("abcdefgh"))
+	faulty.Write([]byte("abcdefgh"))
+	if expected := []int{8, 3, 3, 2}; !slices.Equal(conn.writes, expected) {
+		t.Errorf("expected writes of %v bytes, got %v", expected, conn.writes)
+	}
+}
+
+func TestFaultyConnSplitsWritesRandomly(t *testing.T) {
+	conn := &writeRecordingConn{}
+	faulty := newFaultyConn(conn, "test", faultProfile{maxRandomSegmentSize: 5}, 1, logger.GetQuietLogger(""))
+
+	if _, err := faulty.Write(make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, size := range conn.writes {
+		if size < 1 || size > 5 {
+			t.Errorf("expected segments of 1 to 5 bytes, got %d", size)
+		}
+		total += size
+	}
+	if total != 100 {
+		t.Errorf("expected 100 bytes to be written, got %d", total)
+	}
+}
+
+func TestFaultyConnResetsAfterBytes(t *testing.T) {
+	conn := &writeRecordingConn{}
+	faulty := &faultyConn{Conn: conn, name: "test", profile: faultProfile{resetAfterBytes: 10}, logger: logger.GetQuietLogger("")}
+
+	if n, err := faulty.Write(make([]byte, 6)); err != nil || n != 6 {
+		t.Fatalf("expected first write to succeed, wrote %d: %v", n, err)
+	}
+	n, err := faulty.Write(make([]byte, 6))
+	if n != 4 || !errors.Is(err, errResetByFaultProfile) {
+		t.Errorf("expected second write to stop after 4 bytes with a reset, wrote %d: %v", n, err)
+	}
+	if !conn.closed {
+		t.Error("expected connection to be closed")
+	}
+	if _, err := faulty.Write([]byte{0}); !errors.Is(err, errResetByFaultProfile) {
+		t.Errorf("expected writes after the reset to fail, got %v", err)
+	}
+}
+
+func TestFaultProfileNamed(t *testing.T) {
+	if _, err := faultProfileNamed("one-byte-segments"); err != nil {
+		t.Error(err)
+	}
+	if _, err := faultProfileNamed("unknown"); err == nil {
+		t.Error("expected unknown fault profile to be rejected")
+	}
+}
+
+func TestSlowAcceptDelaysConnectionsWithoutBlockingAccept(t *testing.T) {
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpListener.Close()
+
+	const delay = 200 * time.Millisecond
+	listener := &faultyListener{Listener: tcpListener, name: "test", profile: faultProfile{acceptDelay: delay}, seeds: rand.New(rand.NewSource(1)), logger: logger.GetQuietLogger("")}
+
+	for range 2 {
+		client, err := net.Dial("tcp", tcpListener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+	}
+
+	startedAt := time.Now()
+	first, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if elapsed := time.Since(startedAt); elapsed >= delay {
+		t.Errorf("expected both connections to be accepted without waiting, took %s", elapsed)
+	}
+
+	if _, err := first.Write([]byte{0}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(startedAt); elapsed < delay {
+		t.Errorf("expected the first write to wait for the accept delay, took %s", elapsed)
+	}
+}
@@ -0,0 +1,201 @@
+// Guesses how a wrong info hash was calculated by trying common mistakes
+package internal
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	logger "github.com/codecrafters-io/tester-utils/logger"
+	"github.com/jackpal/bencode-go"
+)
+
+// Permuting more keys than this gets too slow
+const maxPermutedInfoKeys = 7
+
+var knownInfoKeys = map[string]bool{"name": true, "length": true, "piece length": true, "pieces": true, "files": true}
+
+type infoHashMistake struct {
+	message string
+	// variants returns the data that a client making this mistake would have hashed
+	variants func(torrent []byte, info map[string]interface{}) [][]byte
+}
+
+var infoHashMistakes = []infoHashMistake{
+	{
+		message:  "WARNING: In your bencoded info dictionary, ensure that keys appear in sorted order.",
+		variants: unsortedInfoVariants,
+	},
+	{
+		message:  "Your info hash was calculated without some keys of the info dictionary. Hash every key, including ones your client doesn't use.",
+		variants: droppedKeysInfoVariants,
+	},
+	{
+		message: "Your info hash was calculated with integers encoded as strings. Integers like length need to be re-encoded as i<number>e.",
+		variants: func(torrent []byte, info map[string]interface{}) [][]byte {
+			return [][]byte{encodeBencode(mapInfoValues(info, func(value interface{}) interface{} {
+				if number, ok := value.(int); ok {
+					return fmt.Sprintf("%d", number)
+				}
+				return value
+			}))}
+		},
+	},
+	{
+		message: "Your info hash was calculated with pieces encoded as hex. Hash the raw 20 byte SHA-1 hashes as they appear in the torrent file.",
+		variants: func(torrent []byte, info map[string]interface{}) [][]byte {
+			pieces, ok := info["pieces"].(string)
+			if !ok {
+				return nil
+			}
+			hexPieces := copyDict(info)
+			hexPieces["pieces"] = hex.EncodeToString([]byte(pieces))
+			return [][]byte{encodeBencode(hexPieces)}
+		},
+	},
+	{
+		message: "Your info hash was calculated after the pieces string went through a text encoding conversion. Treat bencoded strings as raw bytes, not UTF-8 text.",
+		variants: func(torrent []byte, info map[string]interface{}) [][]byte {
+			latin1ToUTF8 := mapInfoValues(info, func(value interface{}) interface{} {
+				if s, ok := value.(string); ok {
+					var converted strings.Builder
+					for _, b := range []byte(s) {
+						converted.WriteRune(rune(b))
+					}
+					return converted.String()
+				}
+				return value
+			})
+			replacedInvalid := mapInfoValues(info, func(value interface{}) interface{} {
+				if s, ok := value.(string); ok {
+					return strings.ToValidUTF8(s, "\uFFFD")
+				}
+				return value
+			})
+			return [][]byte{encodeBencode(latin1ToUTF8), encodeBencode(replacedInvalid)}
+		},
+	},
+	{
+		message: "Your info hash is the SHA-1 of the whole torrent file. It needs to be the SHA-1 of just the bencoded info dictionary.",
+		variants: func(torrent []byte, info map[string]interface{}) [][]byte {
+			return [][]byte{torrent}
+		},
+	},
+}
+
+// diagnoseInfoHash returns a message describing the mistake that produces an info hash found in the
+// output, or an empty string if none of the known mistakes match
+func diagnoseInfoHash(torrent []byte, output string) (string, error) {
+	decoded, err := bencode.Decode(bytes.NewReader(torrent))
+	if err != nil {
+		return "", err
+	}
+	converted, err := fromDecodedBencode(decoded)
+	if err != nil {
+		return "", err
+	}
+	dict, ok := converted.(map[string]interface{})
+	if !ok {
+		return "", errors.New("torrent file isn't a dictionary")
+	}
+	info, ok := dict["info"].(map[string]interface{})
+	if !ok {
+		return "", errors.New("torrent file doesn't have an info dictionary")
+	}
+
+	output = strings.ToLower(output)
+	for _, mistake := range infoHashMistakes {
+		for _, variant := range mistake.variants(torrent, info) {
+			if strings.Contains(output, fmt.Sprintf("%x", sha1.Sum(variant))) {
+				return mistake.message, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// logInfoHashMistake logs the mistake behind a wrong info hash in the output, if it's a known one
+func logInfoHashMistake(logger *logger.Logger, torrentPath string, output []byte) {
+	torrent, err := os.ReadFile(torrentPath)
+	if err != nil {
+		return
+	}
+
+	message, err := diagnoseInfoHash(torrent, string(output))
+	if err != nil {
+		logger.Debugf("Couldn't diagnose info hash: %s", err)
+		return
+	}
+	if message != "" {
+		logger.Errorln(message)
+	}
+}
+
+func unsortedInfoVariants(torrent []byte, info map[string]interface{}) [][]byte {
+	keys := sortedKeys(info)
+	if len(keys) > maxPermutedInfoKeys {
+		return nil
+	}
+
+	sorted := string(encodeBencode(info))
+	var variants [][]byte
+	for _, permutation := range permutations(keys) {
+		var buffer bytes.Buffer
+		buffer.WriteByte('d')
+		for _, key := range permutation {
+			writeBencode(&buffer, key)
+			writeBencode(&buffer, info[key])
+		}
+		buffer.WriteByte('e')
+		if buffer.String() != sorted {
+			variants = append(variants, buffer.Bytes())
+		}
+	}
+	return variants
+}
+
+func droppedKeysInfoVariants(torrent []byte, info map[string]interface{}) [][]byte {
+	var variants [][]byte
+	withoutUnknown := make(map[string]interface{})
+	for key, value := range info {
+		if knownInfoKeys[key] {
+			withoutUnknown[key] = value
+		} else {
+			withoutSingleKey := copyDict(info)
+			delete(withoutSingleKey, key)
+			variants = append(variants, encodeBencode(withoutSingleKey))
+		}
+	}
+	if len(withoutUnknown) < len(info) {
+		variants = append(variants, encodeBencode(withoutUnknown))
+	}
+	return variants
+}
+
+func mapInfoValues(info map[string]interface{}, f func(interface{}) interface{}) map[string]interface{} {
+	mapped := make(map[string]interface{}, len(info))
+	for key, value := range info {
+		mapped[key] = f(value)
+	}
+	return mapped
+}
+
+func permutations(keys []string) [][]string {
+	if len(keys) <= 1 {
+		return [][]string{append([]string{}, keys...)}
+	}
+
+	var result [][]string
+	for i, key := range keys {
+		rest := append(append([]string{}, keys[:i]...), keys[i+1:]...)
+		for _, permutation := range permutations(rest) {
+			result = append(result, append([]string{key}, permutation...))
+		}
+	}
+	return result
+}
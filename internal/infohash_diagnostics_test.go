@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDiagnoseInfoHash(t *testing.T) {
+	content := bytes.Repeat([]byte{0xde, 0xad, 0xbe, 0xef}, 20000)
+	info := TorrentFileInfo{
+		Name:        "sample.bin",
+		Length:      len(content),
+		Pieces:      createPiecesStrFromBytes(content, 16*1024),
+		PieceLength: 16 * 1024,
+		Private:     1,
+	}
+	torrent := encodeBencode(map[string]interface{}{
+		"announce": "http://127.0.0.1/announce",
+		"info": map[string]interface{}{
+			"name":         info.Name,
+			"length":       info.Length,
+			"pieces":       info.Pieces,
+			"piece length": info.PieceLength,
+			"private":      info.Private,
+		},
+	})
+
+	correctHash, err := info.hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"unsorted keys":   fmt.Sprintf("d4:name%d:%s6:lengthi%de12:piece lengthi%de6:pieces%d:%s7:privatei1ee", len(info.Name), info.Name, info.Length, info.PieceLength, len(info.Pieces), info.Pieces),
+		"without private": fmt.Sprintf("d6:lengthi%de4:name%d:%s12:piece lengthi%de6:pieces%d:%se", info.Length, len(info.Name), info.Name, info.PieceLength, len(info.Pieces), info.Pieces),
+		"string integers": fmt.Sprintf("d6:length%d:%d4:name%d:%s12:piece length5:%d6:pieces%d:%s7:private1:1e", len(fmt.Sprint(info.Length)), info.Length, len(info.Name), info.Name, info.PieceLength, len(info.Pieces), info.Pieces),
+		"hex pieces":      fmt.Sprintf("d6:lengthi%de4:name%d:%s12:piece lengthi%de6:pieces%d:%s7:privatei1ee", info.Length, len(info.Name), info.Name, info.PieceLength, 2*len(info.Pieces), hex.EncodeToString([]byte(info.Pieces))),
+		"whole file":      string(torrent),
+	}
+	expectedMessages := map[string]string{
+		"unsorted keys":   "ensure that keys appear in sorted order",
+		"without private": "without some keys",
+		"string integers": "integers encoded as strings",
+		"hex pieces":      "pieces encoded as hex",
+		"whole file":      "whole torrent file",
+	}
+
+	for name, hashed := range tests {
+		output := fmt.Sprintf("Info Hash: %x\n", sha1.Sum([]byte(hashed)))
+		message, err := diagnoseInfoHash(torrent, output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(message, expectedMessages[name]) {
+			t.Errorf("%s: expected message containing %q, got %q", name, expectedMessages[name], message)
+		}
+	}
+
+	message, err := diagnoseInfoHash(torrent, fmt.Sprintf("Info Hash: %x\n", correctHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message != "" {
+		t.Errorf("expected no diagnosis for the correct info hash, got %q", message)
+	}
+}
@@ -0,0 +1,131 @@
+// Starting and stopping the trackers, peers and web seeds of a test case
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logger "github.com/codecrafters-io/tester-utils/logger"
+)
+
+// serverShutdownTimeout is how long servers get to finish their requests and connection handlers once their test
+// case ended
+const serverShutdownTimeout = 2 * time.Second
+
+// serverLifecycle runs the servers of a test case until its context is cancelled, and keeps track of their
+// goroutines and listeners so that stop can tell whether they all finished
+type serverLifecycle struct {
+	ctx     context.Context
+	wg      sync.WaitGroup
+	running atomic.Int64
+
+	mu        sync.Mutex
+	listeners map[io.Closer]string
+}
+
+// Servers started without a test case context run until the process exits
+var backgroundServerLifecycle = newServerLifecycle(context.Background())
+
+func newServerLifecycle(ctx context.Context) *serverLifecycle {
+	return &serverLifecycle{ctx: ctx, listeners: make(map[io.Closer]string)}
+}
+
+// serverLifecycleFor returns the server lifecycle of the test case that owns the logger
+func serverLifecycleFor(logger *logger.Logger) *serverLifecycle {
+	if testCase := testCaseContextFor(logger); testCase != nil {
+		return testCase.servers
+	}
+	return backgroundServerLifecycle
+}
+
+// goServe runs serve in a goroutine that stop waits for
+func (l *serverLifecycle) goServe(serve func()) {
+	l.wg.Add(1)
+	l.running.Add(1)
+	go func() {
+		defer l.wg.Done()
+		defer l.running.Add(-1)
+		serve()
+	}()
+}
+
+// track closes the listener when the test case ends. The returned function closes it earlier, and has to be called
+// once it's no longer used.
+func (l *serverLifecycle) track(listener io.Closer, name string) (release func()) {
+	l.mu.Lock()
+	l.listeners[listener] = name
+	l.mu.Unlock()
+
+	stopClosing := context.AfterFunc(l.ctx, func() { listener.Close() })
+	return func() {
+		stopClosing()
+		listener.Close()
+
+		l.mu.Lock()
+		delete(l.listeners, listener)
+		l.mu.Unlock()
+	}
+}
+
+// serveHTTP serves handler on the listener until the test case ends, then waits for the requests in flight
+func (l *serverLifecycle) serveHTTP(listener net.Listener, handler http.Handler, release func(), logger *logger.Logger) {
+	server := &http.Server{Handler: handler}
+
+	l.goServe(func() {
+		defer release()
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("Error: %s", err)
+		}
+	})
+
+	l.goServe(func() {
+		<-l.ctx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			server.Close()
+		}
+	})
+}
+
+// stop waits for the servers to finish once the context was cancelled, and returns an error describing the
+// goroutines and listeners that are left over
+func (l *serverLifecycle) stop(timeout time.Duration) error {
+	finished := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(finished)
+	}()
+
+	var leaks []string
+	select {
+	case <-finished:
+	case <-time.After(timeout):
+		leaks = append(leaks, fmt.Sprintf("%d server goroutines still running after %s", l.running.Load(), timeout))
+	}
+
+	l.mu.Lock()
+	var listeners []string
+	for _, name := range l.listeners {
+		listeners = append(listeners, name)
+	}
+	l.mu.Unlock()
+	sort.Strings(listeners)
+	for _, name := range listeners {
+		leaks = append(leaks, fmt.Sprintf("%s wasn't released", name))
+	}
+
+	if len(leaks) > 0 {
+		return errors.New(strings.Join(leaks, ", "))
+	}
+	return nil
+}
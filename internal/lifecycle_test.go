@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func freeAddress(t *testing.T) string {
+	port, err := findFreePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("127.0.0.1:%d", port)
+}
+
+func assertAddressIsFree(t *testing.T, address string) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatalf("expected %s to be free again: %v", address, err)
+	}
+	listener.Close()
+}
+
+func TestPeerStopsWhenTestCaseEnds(t *testing.T) {
+	context, logger := registerTestCaseContext(t)
+	address := freeAddress(t)
+
+	handlerStarted := make(chan struct{})
+	handlerDone := make(chan struct{})
+	startPeer(PeerConnectionParams{address: address, logger: logger}, func(conn net.Conn, params PeerConnectionParams) error {
+		defer close(handlerDone)
+		close(handlerStarted)
+		_, err := io.ReadAll(conn)
+		return err
+	})
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("couldn't connect to the peer: %v", err)
+	}
+	defer conn.Close()
+	<-handlerStarted
+
+	context.cancel()
+	if err := context.servers.stop(time.Second); err != nil {
+		t.Fatalf("expected the peer to stop when the test case ends: %v", err)
+	}
+	select {
+	case <-handlerDone:
+	default:
+		t.Error("expected the connection handler to finish before the peer stopped")
+	}
+	assertAddressIsFree(t, address)
+}
+
+func TestTrackerStopsWhenTestCaseEnds(t *testing.T) {
+	context, logger := registerTestCaseContext(t)
+	address := freeAddress(t)
+
+	startTracker(TrackerParams{trackerAddress: address, peersResponse: createEmptyPeersResponse(), logger: logger})
+	response, err := http.Get(fmt.Sprintf("http://%s/announce", address))
+	if err != nil {
+		t.Fatalf("couldn't reach the tracker: %v", err)
+	}
+	response.Body.Close()
+
+	context.cancel()
+	if err := context.servers.stop(time.Second); err != nil {
+		t.Fatalf("expected the tracker to stop when the test case ends: %v", err)
+	}
+	assertAddressIsFree(t, address)
+}
+
+func TestServerLifecycleReportsLeaks(t *testing.T) {
+	context, _ := registerTestCaseContext(t)
+	blocked := make(chan struct{})
+	defer close(blocked)
+
+	context.servers.goServe(func() { <-blocked })
+	context.servers.track(io.NopCloser(nil), "peer on 127.0.0.1:1")
+
+	context.cancel()
+	err := context.servers.stop(10 * time.Millisecond)
+	if err == nil {
+		t.Fatal("expected the leaked goroutine and listener to be reported")
+	}
+	for _, expected := range []string{"1 server goroutines still running", "peer on 127.0.0.1:1 wasn't released"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in %q", expected, err)
+		}
+	}
+}
+
+func TestConcurrentTestCasesDontShareServers(t *testing.T) {
+	first, firstLogger := registerTestCaseContext(t)
+	_, secondLogger := registerTestCaseContext(t)
+	firstAddress, secondAddress := freeAddress(t), freeAddress(t)
+
+	greet := func(greeting string) ConnectionHandler {
+		return func(conn net.Conn, params PeerConnectionParams) error {
+			defer conn.Close()
+			_, err := conn.Write([]byte(greeting))
+			return err
+		}
+	}
+	startPeer(PeerConnectionParams{address: firstAddress, logger: firstLogger}, greet("first"))
+	startPeer(PeerConnectionParams{address: secondAddress, logger: secondLogger}, greet("second"))
+
+	assertGreeting := func(address, expected string) {
+		t.Helper()
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatalf("couldn't connect to the peer on %s: %v", address, err)
+		}
+		defer conn.Close()
+		greeting, err := io.ReadAll(conn)
+		if err != nil || string(greeting) != expected {
+			t.Fatalf("expected %q from %s, got %q (%v)", expected, address, greeting, err)
+		}
+	}
+	assertGreeting(firstAddress, "first")
+	assertGreeting(secondAddress, "second")
+
+	first.cancel()
+	if err := first.servers.stop(time.Second); err != nil {
+		t.Fatalf("expected the first test case's peer to stop: %v", err)
+	}
+	assertAddressIsFree(t, firstAddress)
+	assertGreeting(secondAddress, "second")
+}
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 
@@ -23,10 +24,46 @@ const (
 	RequestMetadataExtensionMsgType uint8 = 0
 	DataMetadataExtensionMsgType    uint8 = 1
 
-	MsgBitfield messageID = 5
-	MsgExtended messageID = 20
+	MsgChoke         messageID = 0
+	MsgUnchoke       messageID = 1
+	MsgInterested    messageID = 2
+	MsgNotInterested messageID = 3
+	MsgHave          messageID = 4
+	MsgBitfield      messageID = 5
+	MsgRequest       messageID = 6
+	MsgPiece         messageID = 7
+	MsgCancel        messageID = 8
+	MsgPort          messageID = 9
+	MsgExtended      messageID = 20
+	MsgHashRequest   messageID = 21
+	MsgHashes        messageID = 22
+	MsgHashReject    messageID = 23
 )
 
+var messageNames = map[messageID]string{
+	MsgChoke:         "choke",
+	MsgUnchoke:       "unchoke",
+	MsgInterested:    "interested",
+	MsgNotInterested: "not interested",
+	MsgHave:          "have",
+	MsgBitfield:      "bitfield",
+	MsgRequest:       "request",
+	MsgPiece:         "piece",
+	MsgCancel:        "cancel",
+	MsgPort:          "port",
+	MsgExtended:      "extended",
+	MsgHashRequest:   "hash request",
+	MsgHashes:        "hashes",
+	MsgHashReject:    "hash reject",
+}
+
+func (id messageID) String() string {
+	if name, exists := messageNames[id]; exists {
+		return name
+	}
+	return fmt.Sprintf("unknown (%d)", uint8(id))
+}
+
 func sendBitfieldMessage(conn net.Conn, payload []byte, logger *logger.Logger) (err error) {
 	defer logOnExit(logger, &err)
 
@@ -112,9 +149,27 @@ func readMessage(r io.Reader, logger *logger.Logger) (*Message, error) {
 		Payload: messageBuf[1:],
 	}
 
+	if err := protocolViolationOf(r); err != nil {
+		return nil, err
+	}
+
 	return &m, nil
 }
 
+// protocolValidator is implemented by connections that check the order of the messages going through them
+type protocolValidator interface {
+	protocolViolation() error
+}
+
+// protocolViolationOf returns the first message sent out of order on the connection, or nil if it doesn't check
+// the order of messages. Connections that wrap another one forward its violations.
+func protocolViolationOf(conn any) error {
+	if validator, ok := conn.(protocolValidator); ok {
+		return validator.protocolViolation()
+	}
+	return nil
+}
+
 type bencodeMetadataExtensionMsg struct {
 	Piece     int   `bencode:"piece"`
 	TotalSize int   `bencode:"total_size,omitempty"`
@@ -0,0 +1,192 @@
+// Performance metrics of every stage, compared against the metrics of an earlier run to catch slow clients
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	logger "github.com/codecrafters-io/tester-utils/logger"
+)
+
+const (
+	// metricsPathEnvVar names the file the metrics of the run are written to, it can be used as a baseline later
+	metricsPathEnvVar = "CODECRAFTERS_METRICS_PATH"
+	// metricsBaselinePathEnvVar names a metrics file of an earlier run to compare with
+	metricsBaselinePathEnvVar = "CODECRAFTERS_METRICS_BASELINE_PATH"
+	// metricsToleranceEnvVar is how many percent a metric can grow over its baseline before it's flagged
+	metricsToleranceEnvVar = "CODECRAFTERS_METRICS_TOLERANCE"
+)
+
+const defaultMetricsTolerancePercent = 50
+
+// Stages that finish less than this much slower than the baseline aren't flagged, short stages vary a lot in
+// relative terms
+const minDurationRegressionSeconds = 0.5
+
+type stageMetrics struct {
+	DurationSeconds float64 `json:"duration_seconds"`
+	// Bytes sent and received by the peers, trackers and web seeds the tester runs
+	BytesSent     int64 `json:"bytes_sent"`
+	BytesReceived int64 `json:"bytes_received"`
+	// Connections the user's client opened to them
+	Connections int64 `json:"connections"`
+	// Piece requests sent to peers and HTTP requests sent to trackers and web seeds
+	Requests int64 `json:"requests"`
+}
+
+type metricsFile struct {
+	Stages map[string]stageMetrics `json:"stages"`
+}
+
+// metricsCounters are updated by the peers, trackers and web seeds of a test case while it runs
+type metricsCounters struct {
+	bytesSent     atomic.Int64
+	bytesReceived atomic.Int64
+	connections   atomic.Int64
+	requests      atomic.Int64
+}
+
+// metricsRecorder collects the metrics of every stage and flags those that regressed from the baseline
+type metricsRecorder struct {
+	mu        sync.Mutex
+	path      string
+	baseline  map[string]stageMetrics
+	tolerance float64
+	stages    map[string]stageMetrics
+}
+
+func newMetricsRecorder(env map[string]string) (*metricsRecorder, error) {
+	recorder := &metricsRecorder{
+		path:      env[metricsPathEnvVar],
+		tolerance: defaultMetricsTolerancePercent / 100.0,
+		stages:    make(map[string]stageMetrics),
+	}
+
+	if tolerance := env[metricsToleranceEnvVar]; tolerance != "" {
+		percent, err := strconv.ParseFloat(tolerance, 64)
+		if err != nil || percent < 0 {
+			return nil, fmt.Errorf("invalid %s %q, expected a percentage like 50", metricsToleranceEnvVar, tolerance)
+		}
+		recorder.tolerance = percent / 100
+	}
+
+	if baselinePath := env[metricsBaselinePathEnvVar]; baselinePath != "" {
+		contents, err := os.ReadFile(baselinePath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading metrics baseline: %v", err)
+		}
+		var baseline metricsFile
+		if err := json.Unmarshal(contents, &baseline); err != nil {
+			return nil, fmt.Errorf("error parsing metrics baseline %s: %v", baselinePath, err)
+		}
+		recorder.baseline = baseline.Stages
+	}
+
+	return recorder, nil
+}
+
+// record keeps the metrics of a stage and returns how they regressed from the baseline
+func (r *metricsRecorder) record(slug string, metrics stageMetrics) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stages[slug] = metrics
+	baseline, exists := r.baseline[slug]
+	if !exists {
+		return nil
+	}
+	return compareMetrics(metrics, baseline, r.tolerance)
+}
+
+func (r *metricsRecorder) write() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.path == "" {
+		return nil
+	}
+	encoded, err := json.MarshalIndent(metricsFile{Stages: r.stages}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, append(encoded, '\n'), 0644)
+}
+
+// compareMetrics describes every metric that grew by more than the tolerance, a fraction of the baseline
+func compareMetrics(current, baseline stageMetrics, tolerance float64) []string {
+	var regressions []string
+	check := func(name string, current, baseline float64, format string) {
+		if baseline <= 0 || current <= baseline*(1+tolerance) {
+			return
+		}
+		regressions = append(regressions, fmt.Sprintf("%s grew from "+format+" to "+format+" (+%.0f%%)", name, baseline, current, (current/baseline-1)*100))
+	}
+
+	if current.DurationSeconds-baseline.DurationSeconds >= minDurationRegressionSeconds {
+		check("duration", current.DurationSeconds, baseline.DurationSeconds, "%.2fs")
+	}
+	check("bytes sent", float64(current.BytesSent), float64(baseline.BytesSent), "%.0f")
+	check("bytes received", float64(current.BytesReceived), float64(baseline.BytesReceived), "%.0f")
+	check("connections", float64(current.Connections), float64(baseline.Connections), "%.0f")
+	check("requests", float64(current.Requests), float64(baseline.Requests), "%.0f")
+	return regressions
+}
+
+// meterListener counts the connections accepted by the listener and the bytes exchanged on them in the metrics
+// of the test case that owns the logger
+func meterListener(listener net.Listener, logger *logger.Logger) net.Listener {
+	testCase := testCaseContextFor(logger)
+	if testCase == nil {
+		return listener
+	}
+	return &meteredListener{Listener: listener, counters: &testCase.counters}
+}
+
+type meteredListener struct {
+	net.Listener
+	counters *metricsCounters
+}
+
+func (l *meteredListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.counters.connections.Add(1)
+	return &meteredConn{Conn: conn, counters: l.counters}, nil
+}
+
+type meteredConn struct {
+	net.Conn
+	counters *metricsCounters
+}
+
+func (c *meteredConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.counters.bytesReceived.Add(int64(n))
+	return n, err
+}
+
+func (c *meteredConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.counters.bytesSent.Add(int64(n))
+	return n, err
+}
+
+// meterRequests counts every HTTP request in the metrics of the test case that owns the logger
+func meterRequests(handler http.Handler, logger *logger.Logger) http.Handler {
+	testCase := testCaseContextFor(logger)
+	if testCase == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testCase.counters.requests.Add(1)
+		handler.ServeHTTP(w, r)
+	})
+}
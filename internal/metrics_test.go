@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCompareMetrics(t *testing.T) {
+	baseline := stageMetrics{DurationSeconds: 2, BytesSent: 1000, Connections: 1, Requests: 10}
+
+	if regressions := compareMetrics(stageMetrics{DurationSeconds: 2.9, BytesSent: 1400, Connections: 1, Requests: 14}, baseline, 0.5); len(regressions) != 0 {
+		t.Errorf("expected no regressions within the tolerance, got %v", regressions)
+	}
+
+	regressions := compareMetrics(stageMetrics{DurationSeconds: 4, BytesSent: 1000, BytesReceived: 50, Connections: 3, Requests: 10}, baseline, 0.5)
+	expected := []string{"duration grew from 2.00s to 4.00s (+100%)", "connections grew from 1 to 3 (+200%)"}
+	if !slices.Equal(regressions, expected) {
+		t.Errorf("expected %v, got %v", expected, regressions)
+	}
+}
+
+func TestCompareMetricsIgnoresSmallDurationChanges(t *testing.T) {
+	if regressions := compareMetrics(stageMetrics{DurationSeconds: 0.3}, stageMetrics{DurationSeconds: 0.1}, 0.5); len(regressions) != 0 {
+		t.Errorf("expected short stages to not be flagged, got %v", regressions)
+	}
+}
+
+func TestMetricsRecorderBaseline(t *testing.T) {
+	directory := t.TempDir()
+	baselinePath := filepath.Join(directory, "baseline.json")
+
+	recorder, err := newMetricsRecorder(map[string]string{metricsPathEnvVar: baselinePath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.record("nd2", stageMetrics{DurationSeconds: 1, Requests: 4})
+	if err := recorder.write(); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder, err = newMetricsRecorder(map[string]string{metricsBaselinePathEnvVar: baselinePath, metricsToleranceEnvVar: "10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if regressions := recorder.record("nd2", stageMetrics{DurationSeconds: 1, Requests: 5}); len(regressions) != 1 {
+		t.Errorf("expected the requests to be flagged, got %v", regressions)
+	}
+	if regressions := recorder.record("jv8", stageMetrics{DurationSeconds: 10}); len(regressions) != 0 {
+		t.Errorf("expected stages missing from the baseline to not be flagged, got %v", regressions)
+	}
+}
+
+func TestMetricsRecorderRejectsInvalidTolerance(t *testing.T) {
+	if _, err := newMetricsRecorder(map[string]string{metricsToleranceEnvVar: "fast"}); err == nil {
+		t.Error("expected invalid tolerance to be rejected")
+	}
+	if _, err := newMetricsRecorder(map[string]string{metricsBaselinePathEnvVar: filepath.Join(os.TempDir(), "missing-baseline.json")}); err == nil {
+		t.Error("expected missing baseline to be rejected")
+	}
+}
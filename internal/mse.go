@@ -0,0 +1,225 @@
+// Receiving side of the Message Stream Encryption (MSE/PE) handshake
+package internal
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+
+	logger "github.com/codecrafters-io/tester-utils/logger"
+)
+
+const (
+	msePublicKeyLength  = 96
+	mseMaxPaddingLength = 512
+
+	mseCryptoPlaintext uint32 = 0x01
+	mseCryptoRC4       uint32 = 0x02
+)
+
+var (
+	msePrime, _             = new(big.Int).SetString("FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245E485B576625E7EC6F44C42E9A63A36210000000000090563", 16)
+	mseGenerator            = big.NewInt(2)
+	mseVerificationConstant = make([]byte, 8)
+)
+
+type encryptedConn struct {
+	net.Conn
+	reader io.Reader
+	writer io.Writer
+}
+
+func (c *encryptedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *encryptedConn) Write(b []byte) (int, error) {
+	return c.writer.Write(b)
+}
+
+// acceptEncryptedConnection runs the MSE handshake as the receiving peer and returns a
+// connection that decrypts reads and encrypts writes with RC4.
+func acceptEncryptedConnection(conn net.Conn, infoHash [20]byte, logger *logger.Logger) (_ net.Conn, err error) {
+	defer logOnExit(logger, &err)
+
+	logger.Debugln("Waiting to receive MSE public key (Ya)")
+	theirPublicKey := make([]byte, msePublicKeyLength)
+	if _, err := io.ReadFull(conn, theirPublicKey[:20]); err != nil {
+		return nil, fmt.Errorf("MSE step 1 (receive Ya): error reading public key: %v", err)
+	}
+	if theirPublicKey[0] == 19 && string(theirPublicKey[1:20]) == ProtocolName {
+		return nil, errors.New("MSE step 1 (receive Ya): received a plaintext BitTorrent handshake, but this peer only accepts encrypted connections. Start with the Diffie-Hellman key exchange instead")
+	}
+	if _, err := io.ReadFull(conn, theirPublicKey[20:]); err != nil {
+		return nil, fmt.Errorf("MSE step 1 (receive Ya): expected a 96 byte public key: %v", err)
+	}
+
+	y := new(big.Int).SetBytes(theirPublicKey)
+	if y.Cmp(big.NewInt(1)) <= 0 || y.Cmp(msePrime) >= 0 {
+		return nil, errors.New("MSE step 1 (receive Ya): public key is out of range, it needs to be G^Xa mod P encoded as a 96 byte big-endian number")
+	}
+
+	privateKey, publicKey, err := generateMSEKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	padding, err := randomMSEPadding()
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("Sending MSE public key (Yb) with %d bytes of padding", len(padding))
+	if _, err := conn.Write(append(publicKey, padding...)); err != nil {
+		return nil, fmt.Errorf("MSE step 2 (send Yb): %v", err)
+	}
+
+	secret := padMSEKey(new(big.Int).Exp(y, privateKey, msePrime).Bytes())
+
+	logger.Debugln("Waiting to receive HASH('req1', S)")
+	if err := synchronizeOnMSEHash(conn, mseHash("req1", secret)); err != nil {
+		return nil, fmt.Errorf("MSE step 3 (synchronize on HASH('req1', S)): %v", err)
+	}
+
+	obfuscatedInfoHash := make([]byte, 20)
+	if _, err := io.ReadFull(conn, obfuscatedInfoHash); err != nil {
+		return nil, fmt.Errorf("MSE step 3 (receive HASH('req2', SKEY) xor HASH('req3', S)): %v", err)
+	}
+	expectedObfuscatedInfoHash := xorBytes(mseHash("req2", infoHash[:]), mseHash("req3", secret))
+	if !bytes.Equal(obfuscatedInfoHash, expectedObfuscatedInfoHash) {
+		return nil, errors.New("MSE step 3 (receive HASH('req2', SKEY) xor HASH('req3', S)): value does not match, SKEY needs to be the 20 byte info hash of the torrent")
+	}
+
+	decrypter, err := newMSECipher("keyA", secret, infoHash[:])
+	if err != nil {
+		return nil, err
+	}
+	encrypter, err := newMSECipher("keyB", secret, infoHash[:])
+	if err != nil {
+		return nil, err
+	}
+	reader := cipher.StreamReader{S: decrypter, R: conn}
+
+	header := make([]byte, 8+4+2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("MSE step 3 (receive ENCRYPT(VC, crypto_provide, len(PadC))): %v", err)
+	}
+	if !bytes.Equal(header[:8], mseVerificationConstant) {
+		return nil, fmt.Errorf("MSE step 3 (receive ENCRYPT(VC)): verification constant needs to decrypt to 8 zero bytes, got %v. Check that RC4 is keyed with HASH('keyA', S, SKEY) and the first 1024 bytes of keystream are discarded", header[:8])
+	}
+	cryptoProvide := binary.BigEndian.Uint32(header[8:12])
+	paddingLength := int(binary.BigEndian.Uint16(header[12:14]))
+	if paddingLength > mseMaxPaddingLength {
+		return nil, fmt.Errorf("MSE step 3 (receive len(PadC)): padding length needs to be at most %d, got %d", mseMaxPaddingLength, paddingLength)
+	}
+	if _, err := io.ReadFull(reader, make([]byte, paddingLength)); err != nil {
+		return nil, fmt.Errorf("MSE step 3 (receive PadC): %v", err)
+	}
+
+	initialPayloadLength := make([]byte, 2)
+	if _, err := io.ReadFull(reader, initialPayloadLength); err != nil {
+		return nil, fmt.Errorf("MSE step 3 (receive len(IA)): %v", err)
+	}
+	initialPayload := make([]byte, binary.BigEndian.Uint16(initialPayloadLength))
+	if _, err := io.ReadFull(reader, initialPayload); err != nil {
+		return nil, fmt.Errorf("MSE step 3 (receive IA): %v", err)
+	}
+
+	logger.Debugf("Received crypto_provide: %#x", cryptoProvide)
+	if cryptoProvide&mseCryptoRC4 == 0 {
+		return nil, fmt.Errorf("MSE step 3 (receive crypto_provide): expected RC4 (0x02) to be offered, got %#x. This peer does not accept plaintext connections", cryptoProvide)
+	}
+
+	response := make([]byte, 8+4+2)
+	binary.BigEndian.PutUint32(response[8:12], mseCryptoRC4)
+	encrypter.XORKeyStream(response, response)
+
+	logger.Debugln("Sending crypto_select: 0x2 (RC4)")
+	if _, err := conn.Write(response); err != nil {
+		return nil, fmt.Errorf("MSE step 4 (send ENCRYPT(VC, crypto_select, len(PadD))): %v", err)
+	}
+
+	return &encryptedConn{
+		Conn:   conn,
+		reader: io.MultiReader(bytes.NewReader(initialPayload), reader),
+		writer: cipher.StreamWriter{S: encrypter, W: conn},
+	}, nil
+}
+
+func generateMSEKeyPair() (*big.Int, []byte, error) {
+	privateKeyBytes := make([]byte, 20)
+	if _, err := rand.Read(privateKeyBytes); err != nil {
+		return nil, nil, err
+	}
+	privateKey := new(big.Int).SetBytes(privateKeyBytes)
+	publicKey := new(big.Int).Exp(mseGenerator, privateKey, msePrime)
+	return privateKey, padMSEKey(publicKey.Bytes()), nil
+}
+
+func randomMSEPadding() ([]byte, error) {
+	var length [2]byte
+	if _, err := rand.Read(length[:]); err != nil {
+		return nil, err
+	}
+	padding := make([]byte, int(binary.BigEndian.Uint16(length[:]))%(mseMaxPaddingLength+1))
+	if _, err := rand.Read(padding); err != nil {
+		return nil, err
+	}
+	return padding, nil
+}
+
+// synchronizeOnMSEHash consumes PadA until the given hash has been read
+func synchronizeOnMSEHash(r io.Reader, hash []byte) error {
+	window := make([]byte, 0, mseMaxPaddingLength+len(hash))
+	b := make([]byte, 1)
+	for len(window) < cap(window) {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return fmt.Errorf("hash not found after %d bytes of padding: %v", len(window), err)
+		}
+		window = append(window, b[0])
+		if bytes.HasSuffix(window, hash) {
+			return nil
+		}
+	}
+	return fmt.Errorf("hash not found within %d bytes following the public key. Check that S is computed as Yb^Xa mod P and padded to 96 bytes, and that PadA is at most %d bytes", cap(window), mseMaxPaddingLength)
+}
+
+func newMSECipher(name string, secret []byte, infoHash []byte) (*rc4.Cipher, error) {
+	c, err := rc4.NewCipher(mseHash(name, secret, infoHash))
+	if err != nil {
+		return nil, err
+	}
+	discard := make([]byte, 1024)
+	c.XORKeyStream(discard, discard)
+	return c, nil
+}
+
+func mseHash(prefix string, parts ...[]byte) []byte {
+	hasher := sha1.New()
+	hasher.Write([]byte(prefix))
+	for _, part := range parts {
+		hasher.Write(part)
+	}
+	return hasher.Sum(nil)
+}
+
+func padMSEKey(key []byte) []byte {
+	padded := make([]byte, msePublicKeyLength)
+	copy(padded[msePublicKeyLength-len(key):], key)
+	return padded
+}
+
+func xorBytes(a, b []byte) []byte {
+	result := make([]byte, len(a))
+	for i := range a {
+		result[i] = a[i] ^ b[i]
+	}
+	return result
+}
@@ -0,0 +1,190 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/tester-utils/logger"
+)
+
+func initiateEncryptedConnection(t *testing.T, conn net.Conn, infoHash [20]byte, initialPayload []byte) net.Conn {
+	privateKey, publicKey, err := generateMSEKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(append(publicKey, 1, 2, 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	theirPublicKey := make([]byte, msePublicKeyLength)
+	if _, err := io.ReadFull(conn, theirPublicKey); err != nil {
+		t.Fatal(err)
+	}
+	secret := padMSEKey(new(big.Int).Exp(new(big.Int).SetBytes(theirPublicKey), privateKey, msePrime).Bytes())
+
+	encrypter, _ := newMSECipher("keyA", secret, infoHash[:])
+	decrypter, _ := newMSECipher("keyB", secret, infoHash[:])
+
+	var request bytes.Buffer
+	request.Write(mseHash("req1", secret))
+	request.Write(xorBytes(mseHash("req2", infoHash[:]), mseHash("req3", secret)))
+	encrypted := make([]byte, 8+4+2+2)
+	binary.BigEndian.PutUint32(encrypted[8:12], mseCryptoPlaintext|mseCryptoRC4)
+	binary.BigEndian.PutUint16(encrypted[14:16], uint16(len(initialPayload)))
+	encrypted = append(encrypted, initialPayload...)
+	encrypter.XORKeyStream(encrypted, encrypted)
+	request.Write(encrypted)
+	if _, err := conn.Write(request.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The responder's padding is of unknown length, scan for the encrypted VC
+	expectedVC := make([]byte, 8)
+	decrypter.XORKeyStream(expectedVC, expectedVC)
+	if err := synchronizeOnMSEHash(conn, expectedVC); err != nil {
+		t.Fatalf("verification constant not found: %v", err)
+	}
+	reader := cipher.StreamReader{S: decrypter, R: conn}
+	selectBytes := make([]byte, 4+2)
+	if _, err := io.ReadFull(reader, selectBytes); err != nil {
+		t.Fatal(err)
+	}
+	if binary.BigEndian.Uint32(selectBytes[:4]) != mseCryptoRC4 {
+		t.Fatalf("expected RC4 to be selected, got %x", selectBytes[:4])
+	}
+
+	return &encryptedConn{Conn: conn, reader: reader, writer: cipher.StreamWriter{S: encrypter, W: conn}}
+}
+
+func tcpConnPair(t *testing.T) (net.Conn, net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+func TestEncryptedConnectionRoundTrip(t *testing.T) {
+	client, server := tcpConnPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	infoHash := [20]byte{1, 2, 3}
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := acceptEncryptedConnection(server, infoHash, logger.GetQuietLogger(""))
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- conn
+	}()
+
+	conn := initiateEncryptedConnection(t, client, infoHash, []byte("initial payload"))
+	serverConn := <-accepted
+	if serverConn == nil {
+		t.FailNow()
+	}
+
+	received := make([]byte, len("initial payload"))
+	if _, err := io.ReadFull(serverConn, received); err != nil || string(received) != "initial payload" {
+		t.Fatalf("expected initial payload, got %q (%v)", received, err)
+	}
+
+	go serverConn.Write([]byte("reply"))
+	reply := make([]byte, 5)
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "reply" {
+		t.Fatalf("expected reply, got %q (%v)", reply, err)
+	}
+}
+
+func TestEncryptedConnectionRejectsPlaintextHandshake(t *testing.T) {
+	client, server := tcpConnPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	go sendHandshake(client, [8]byte{}, [20]byte{}, [20]byte{})
+
+	_, err := acceptEncryptedConnection(server, [20]byte{}, logger.GetQuietLogger(""))
+	if err == nil || !strings.Contains(err.Error(), "plaintext") {
+		t.Fatalf("expected plaintext handshake to be rejected, got: %v", err)
+	}
+}
+
+func TestEncryptedPeerServesPieces(t *testing.T) {
+	testCase, quietLogger := registerTestCaseContext(t)
+	client, server := tcpConnPair(t)
+	defer client.Close()
+
+	infoHash := [20]byte{1, 2, 3}
+	pieces := [][]byte{bytes.Repeat([]byte("a"), 100), bytes.Repeat([]byte("b"), 50)}
+	handlerDone := make(chan struct{})
+	go func() {
+		defer close(handlerDone)
+		handleRecordedConnection(server, PeerConnectionParams{
+			infoHash:              infoHash,
+			expectedReservedBytes: [][]byte{{0, 0, 0, 0, 0, 0, 0, 0}},
+			bitfield:              fullBitfield(len(pieces)),
+			pieces:                pieces,
+			requiresEncryption:    true,
+			logger:                quietLogger,
+		}, handleEncryptedPeer)
+	}()
+
+	conn := initiateEncryptedConnection(t, client, infoHash, nil)
+	if err := sendHandshake(conn, [8]byte{}, infoHash, [20]byte{4}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readHandshake(conn, quietLogger); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := readMessage(conn, quietLogger); err != nil || msg.ID != MsgBitfield {
+		t.Fatalf("expected a bitfield, got %v (%v)", msg, err)
+	}
+
+	sendMessage(conn, &Message{ID: MsgInterested})
+	if msg, err := readMessage(conn, quietLogger); err != nil || msg.ID != MsgUnchoke {
+		t.Fatalf("expected an unchoke, got %v (%v)", msg, err)
+	}
+
+	request := make([]byte, 12)
+	binary.BigEndian.PutUint32(request[0:4], 1)
+	binary.BigEndian.PutUint32(request[8:12], 50)
+	sendMessage(conn, &Message{ID: MsgRequest, Payload: request})
+	msg, err := readMessage(conn, quietLogger)
+	if err != nil || msg.ID != MsgPiece || !bytes.Equal(msg.Payload[8:], pieces[1]) {
+		t.Fatalf("expected the second piece, got %v (%v)", msg, err)
+	}
+
+	// The transcript and the protocol state machine see the decrypted messages
+	client.Close()
+	<-handlerDone
+	if len(testCase.transcripts) != 1 {
+		t.Fatalf("expected one transcript, got %d", len(testCase.transcripts))
+	}
+	var kinds []string
+	for _, frame := range testCase.transcripts[0].frames {
+		kinds = append(kinds, frame.kind)
+	}
+	if strings.Join(kinds, " ") != "handshake handshake bitfield interested unchoke request piece" {
+		t.Errorf("expected decrypted frames in the transcript, got %v", kinds)
+	}
+	if err := testCase.protocolViolation(); err != nil {
+		t.Errorf("expected no protocol violation, got %v", err)
+	}
+}
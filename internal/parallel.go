@@ -0,0 +1,193 @@
+// Runs test cases in parallel, each in its own tester process
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+// parallelismEnvVar sets how many test cases run at the same time. Each one runs in its own tester process, since
+// the random numbers that stages draw from are shared by the whole process.
+const parallelismEnvVar = "CODECRAFTERS_PARALLELISM"
+
+// testerCommand returns the command that runs the tester. The internal tests replace it, since their executable is
+// the test binary.
+var testerCommand = func() (*exec.Cmd, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	return exec.Command(path), nil
+}
+
+func parallelismFrom(env map[string]string) (int, error) {
+	value := env[parallelismEnvVar]
+	if value == "" {
+		return 1, nil
+	}
+	parallelism, err := strconv.Atoi(value)
+	if err != nil || parallelism < 1 {
+		return 0, fmt.Errorf("invalid %s %q, expected the number of test cases to run at the same time", parallelismEnvVar, value)
+	}
+	return parallelism, nil
+}
+
+type parallelTestCase struct {
+	slug     string
+	json     json.RawMessage
+	output   bytes.Buffer
+	exitCode int
+	done     chan struct{}
+}
+
+// runInParallel runs every test case in a tester process of its own, at most parallelism at a time. Unlike a
+// sequential run, later test cases still run after one fails. Outputs are printed in the order of the test cases,
+// and the reports and metrics of the processes are added to report and metrics.
+func runInParallel(env map[string]string, parallelism int, report *testReport, metrics *metricsRecorder, out io.Writer) int {
+	var rawTestCases []json.RawMessage
+	if err := json.Unmarshal([]byte(env["CODECRAFTERS_TEST_CASES_JSON"]), &rawTestCases); err != nil {
+		fmt.Fprintf(out, "failed to parse CODECRAFTERS_TEST_CASES_JSON: %v\n", err)
+		return 1
+	}
+
+	tempDir, err := os.MkdirTemp("", "parallel-test-cases")
+	if err != nil {
+		fmt.Fprintf(out, "Error creating temp directory: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(tempDir)
+
+	testCases := make([]*parallelTestCase, len(rawTestCases))
+	for i, raw := range rawTestCases {
+		var fields struct {
+			Slug string `json:"slug"`
+		}
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			fmt.Fprintf(out, "failed to parse CODECRAFTERS_TEST_CASES_JSON: %v\n", err)
+			return 1
+		}
+		testCases[i] = &parallelTestCase{slug: fields.Slug, json: raw, done: make(chan struct{})}
+	}
+
+	slots := make(chan struct{}, parallelism)
+	for i, testCase := range testCases {
+		go func() {
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			defer close(testCase.done)
+			testCase.run(env, filepath.Join(tempDir, strconv.Itoa(i)))
+		}()
+	}
+
+	exitCode := 0
+	for i, testCase := range testCases {
+		<-testCase.done
+		out.Write(testCase.output.Bytes())
+		if testCase.exitCode != 0 {
+			exitCode = 1
+		}
+
+		if report != nil {
+			testCase.addToReport(report, filepath.Join(tempDir, strconv.Itoa(i), "report.json"))
+		}
+		if err := testCase.addToMetrics(metrics, filepath.Join(tempDir, strconv.Itoa(i), "metrics.json")); err != nil {
+			fmt.Fprintf(out, "Error reading metrics of %s: %v\n", testCase.slug, err)
+		}
+	}
+	return exitCode
+}
+
+// run runs the test case in a tester process, which writes its report and metrics to directory
+func (c *parallelTestCase) run(env map[string]string, directory string) {
+	c.exitCode = 1
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		fmt.Fprintf(&c.output, "Error creating temp directory: %v\n", err)
+		return
+	}
+
+	cmd, err := testerCommand()
+	if err != nil {
+		fmt.Fprintf(&c.output, "Error finding the tester executable: %v\n", err)
+		return
+	}
+
+	childEnv := map[string]string{}
+	for key, value := range env {
+		childEnv[key] = value
+	}
+	delete(childEnv, parallelismEnvVar)
+	childEnv["CODECRAFTERS_TEST_CASES_JSON"] = "[" + string(c.json) + "]"
+	if env[reportPathEnvVar] != "" {
+		childEnv[reportPathEnvVar] = filepath.Join(directory, "report.json")
+	}
+	childEnv[metricsPathEnvVar] = filepath.Join(directory, "metrics.json")
+
+	for key, value := range childEnv {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	// Both write to the same buffer, exec only calls Write from one goroutine at a time when they're equal
+	cmd.Stdout = &c.output
+	cmd.Stderr = &c.output
+
+	err = cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		c.exitCode = exitErr.ExitCode()
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(&c.output, "Error running the tester for %s: %v\n", c.slug, err)
+		return
+	}
+	c.exitCode = 0
+}
+
+// addToReport adds the stage from the report of the test case's process. A process that didn't write one, like one
+// that crashed, is reported as a failed stage.
+func (c *parallelTestCase) addToReport(report *testReport, path string) {
+	var childReport testReport
+	contents, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(contents, &childReport)
+	}
+	if err != nil || len(childReport.Stages) == 0 {
+		stage := report.startStage(c.slug)
+		stage.finish(fmt.Errorf("tester process exited with code %d without reporting the stage", c.exitCode))
+		return
+	}
+
+	report.mu.Lock()
+	defer report.mu.Unlock()
+	for _, stage := range childReport.Stages {
+		stage.report = report
+		stage.finished = true
+		report.Stages = append(report.Stages, stage)
+	}
+}
+
+func (c *parallelTestCase) addToMetrics(metrics *metricsRecorder, path string) error {
+	contents, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil // Failed stages have no metrics
+	}
+	if err != nil {
+		return err
+	}
+
+	var childMetrics metricsFile
+	if err := json.Unmarshal(contents, &childMetrics); err != nil {
+		return err
+	}
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	for slug, stageMetrics := range childMetrics.Stages {
+		metrics.stages[slug] = stageMetrics
+	}
+	return nil
+}
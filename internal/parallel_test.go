@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const parallelTesterProcessEnvVar = "BITTORRENT_TESTER_PARALLEL_PROCESS"
+
+// TestParallelTesterProcess is the tester process that runInParallel starts while the other tests run
+func TestParallelTesterProcess(t *testing.T) {
+	if os.Getenv(parallelTesterProcessEnvVar) == "" {
+		t.Skip("only runs as a tester process")
+	}
+
+	env := map[string]string{}
+	for _, entry := range os.Environ() {
+		key, value, _ := strings.Cut(entry, "=")
+		env[key] = value
+	}
+	os.Exit(RunCLI(env))
+}
+
+func TestRunInParallel(t *testing.T) {
+	defer func(original func() (*exec.Cmd, error)) { testerCommand = original }(testerCommand)
+	testerCommand = func() (*exec.Cmd, error) {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestParallelTesterProcess$")
+		return cmd, nil
+	}
+
+	// The scenario builds the program on every command, which is too slow with several stages at once
+	repositoryDir := t.TempDir()
+	build := exec.Command("go", "build", "-o", filepath.Join(repositoryDir, "mybittorrent"), "./cmd/mybittorrent")
+	build.Dir = "./test_helpers/scenarios/local_stages"
+	if output, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("failed to build the local stages scenario: %v\n%s", err, output)
+	}
+	config, err := os.ReadFile("./test_helpers/scenarios/local_stages/codecrafters.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(repositoryDir, "codecrafters.yml"), config, 0644); err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\nexec \"$(dirname \"$0\")/mybittorrent\" \"$@\"\n"
+	if err := os.WriteFile(filepath.Join(repositoryDir, "your_bittorrent.sh"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	slugs := []string{"bm7", "bb8", "be9"}
+	var testCases []map[string]string
+	for _, slug := range slugs {
+		testCases = append(testCases, map[string]string{"slug": slug, "tester_log_prefix": "tester::#" + slug, "title": "Stage " + slug})
+	}
+	testCasesJSON, err := json.Marshal(testCases)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	env := map[string]string{
+		parallelTesterProcessEnvVar:    "true",
+		parallelismEnvVar:              "2",
+		reportPathEnvVar:               reportPath,
+		randomSeedEnvVar:               "1234567890",
+		"CODECRAFTERS_REPOSITORY_DIR":  repositoryDir,
+		"CODECRAFTERS_TEST_CASES_JSON": string(testCasesJSON),
+		"PATH":                         os.Getenv("PATH"),
+		"HOME":                         os.Getenv("HOME"),
+	}
+	metrics, err := newMetricsRecorder(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := newTestReport(reportPath)
+
+	var output bytes.Buffer
+	if exitCode := runInParallel(env, 2, report, metrics, &output); exitCode != 0 {
+		t.Fatalf("expected every stage to pass, exit code %d:\n%s", exitCode, output.String())
+	}
+
+	lastIndex := -1
+	for _, slug := range slugs {
+		index := strings.Index(output.String(), "[tester::#"+slug+"] \033[0m\033[92mTest passed.")
+		if index <= lastIndex {
+			t.Errorf("expected the output of %s to follow the earlier stages:\n%s", slug, output.String())
+		}
+		lastIndex = index
+	}
+
+	if len(report.Stages) != len(slugs) {
+		t.Fatalf("expected %d stages in the report, got %d", len(slugs), len(report.Stages))
+	}
+	for i, stage := range report.Stages {
+		if stage.Slug != slugs[i] || stage.Status != "passed" {
+			t.Errorf("expected stage %s to pass, got %s %s", slugs[i], stage.Slug, stage.Status)
+		}
+	}
+	if len(metrics.stages) != len(slugs) {
+		t.Errorf("expected metrics of %d stages, got %v", len(slugs), metrics.stages)
+	}
+}
@@ -0,0 +1,328 @@
+// Writes the tester's side of tracker and peer traffic as synthesized IPv4 packets in a pcapng file
+package internal
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	logger "github.com/codecrafters-io/tester-utils/logger"
+)
+
+const (
+	pcapngSectionHeaderBlock = 0x0A0D0D0A
+	pcapngInterfaceBlock     = 0x00000001
+	pcapngEnhancedPacket     = 0x00000006
+	pcapngByteOrderMagic     = 0x1A2B3C4D
+
+	// Packets start with an IPv4 header, there is no link layer
+	pcapLinkTypeRaw = 101
+
+	pcapMaxSegmentSize = 1460
+
+	ipProtocolTCP = 6
+	ipProtocolUDP = 17
+
+	tcpFlagFIN = 0x01
+	tcpFlagSYN = 0x02
+	tcpFlagPSH = 0x08
+	tcpFlagACK = 0x10
+)
+
+// pcapWriter appends packets to a pcapng file. Write errors are dropped, a broken capture must not fail a test.
+type pcapWriter struct {
+	mu     sync.Mutex
+	file   *os.File
+	closed bool
+}
+
+func newPCAPWriter(path string) (*pcapWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &pcapWriter{file: file}
+
+	sectionHeader := make([]byte, 16)
+	binary.LittleEndian.PutUint32(sectionHeader[0:4], pcapngByteOrderMagic)
+	binary.LittleEndian.PutUint16(sectionHeader[4:6], 1)
+	binary.LittleEndian.PutUint16(sectionHeader[6:8], 0)
+	binary.LittleEndian.PutUint64(sectionHeader[8:16], 0xFFFFFFFFFFFFFFFF) // Section length isn't known up front
+
+	interfaceDescription := make([]byte, 8)
+	binary.LittleEndian.PutUint16(interfaceDescription[0:2], pcapLinkTypeRaw)
+
+	if err := w.writeBlock(pcapngSectionHeaderBlock, sectionHeader); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := w.writeBlock(pcapngInterfaceBlock, interfaceDescription); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *pcapWriter) writeBlock(blockType uint32, body []byte) error {
+	length := 12 + (len(body)+3)&^3
+	block := make([]byte, length)
+	binary.LittleEndian.PutUint32(block[0:4], blockType)
+	binary.LittleEndian.PutUint32(block[4:8], uint32(length))
+	copy(block[8:], body)
+	binary.LittleEndian.PutUint32(block[length-4:], uint32(length))
+	_, err := w.file.Write(block)
+	return err
+}
+
+func (w *pcapWriter) writePacket(packet []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+
+	timestamp := uint64(time.Now().UnixMicro())
+	body := make([]byte, 20+len(packet))
+	binary.LittleEndian.PutUint32(body[0:4], 0) // Interface ID
+	binary.LittleEndian.PutUint32(body[4:8], uint32(timestamp>>32))
+	binary.LittleEndian.PutUint32(body[8:12], uint32(timestamp))
+	binary.LittleEndian.PutUint32(body[12:16], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(body[16:20], uint32(len(packet)))
+	copy(body[20:], packet)
+	w.writeBlock(pcapngEnhancedPacket, body)
+}
+
+func (w *pcapWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.file.Close()
+}
+
+type pcapEndpoint struct {
+	ip   [4]byte
+	port uint16
+}
+
+// pcapEndpointFor converts an address to an IPv4 endpoint. Everything listens on 127.0.0.1, so addresses that
+// aren't IPv4 are written as loopback.
+func pcapEndpointFor(addr net.Addr) pcapEndpoint {
+	var ip net.IP
+	var port int
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip, port = a.IP, a.Port
+	case *net.UDPAddr:
+		ip, port = a.IP, a.Port
+	}
+
+	endpoint := pcapEndpoint{ip: [4]byte{127, 0, 0, 1}, port: uint16(port)}
+	if ip4 := ip.To4(); ip4 != nil && !ip4.IsUnspecified() {
+		copy(endpoint.ip[:], ip4)
+	}
+	return endpoint
+}
+
+func ipv4Packet(protocol byte, src pcapEndpoint, dst pcapEndpoint, payload []byte) []byte {
+	packet := make([]byte, 20+len(payload))
+	packet[0] = 0x45 // Version 4, 5 word header
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+	packet[6] = 0x40 // Don't fragment
+	packet[8] = 64   // TTL
+	packet[9] = protocol
+	copy(packet[12:16], src.ip[:])
+	copy(packet[16:20], dst.ip[:])
+	binary.BigEndian.PutUint16(packet[10:12], internetChecksum(packet[:20]))
+	copy(packet[20:], payload)
+	return packet
+}
+
+func tcpPacket(src pcapEndpoint, dst pcapEndpoint, seq uint32, ack uint32, flags byte, payload []byte) []byte {
+	segment := make([]byte, 20+len(payload))
+	binary.BigEndian.PutUint16(segment[0:2], src.port)
+	binary.BigEndian.PutUint16(segment[2:4], dst.port)
+	binary.BigEndian.PutUint32(segment[4:8], seq)
+	binary.BigEndian.PutUint32(segment[8:12], ack)
+	segment[12] = 5 << 4 // 5 word header
+	segment[13] = flags
+	binary.BigEndian.PutUint16(segment[14:16], 65535) // Window
+	copy(segment[20:], payload)
+	binary.BigEndian.PutUint16(segment[16:18], transportChecksum(ipProtocolTCP, src, dst, segment))
+	return ipv4Packet(ipProtocolTCP, src, dst, segment)
+}
+
+func udpPacket(src pcapEndpoint, dst pcapEndpoint, payload []byte) []byte {
+	datagram := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint16(datagram[0:2], src.port)
+	binary.BigEndian.PutUint16(datagram[2:4], dst.port)
+	binary.BigEndian.PutUint16(datagram[4:6], uint16(len(datagram)))
+	copy(datagram[8:], payload)
+	binary.BigEndian.PutUint16(datagram[6:8], transportChecksum(ipProtocolUDP, src, dst, datagram))
+	return ipv4Packet(ipProtocolUDP, src, dst, datagram)
+}
+
+// transportChecksum computes a TCP or UDP checksum, which also covers the IPv4 pseudo header
+func transportChecksum(protocol byte, src pcapEndpoint, dst pcapEndpoint, segment []byte) uint16 {
+	data := make([]byte, 12+len(segment))
+	copy(data[0:4], src.ip[:])
+	copy(data[4:8], dst.ip[:])
+	data[9] = protocol
+	binary.BigEndian.PutUint16(data[10:12], uint16(len(segment)))
+	copy(data[12:], segment)
+	return internetChecksum(data)
+}
+
+func internetChecksum(data []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xFFFF {
+		sum = (sum >> 16) + (sum & 0xFFFF)
+	}
+	return ^uint16(sum)
+}
+
+// capturedConn writes the data of a TCP connection accepted by the tester as packets. The handshake is
+// synthesized when the connection is wrapped, and each side's FIN when it stops sending.
+type capturedConn struct {
+	net.Conn
+	capture *pcapWriter
+
+	mu             sync.Mutex
+	remote         pcapEndpoint
+	local          pcapEndpoint
+	remoteSeq      uint32
+	localSeq       uint32
+	remoteFinished bool
+	localFinished  bool
+}
+
+func newCapturedConn(conn net.Conn, capture *pcapWriter) *capturedConn {
+	c := &capturedConn{
+		Conn:      conn,
+		capture:   capture,
+		remote:    pcapEndpointFor(conn.RemoteAddr()),
+		local:     pcapEndpointFor(conn.LocalAddr()),
+		remoteSeq: 1000,
+		localSeq:  5000,
+	}
+
+	capture.writePacket(tcpPacket(c.remote, c.local, c.remoteSeq, 0, tcpFlagSYN, nil))
+	capture.writePacket(tcpPacket(c.local, c.remote, c.localSeq, c.remoteSeq+1, tcpFlagSYN|tcpFlagACK, nil))
+	c.remoteSeq++
+	c.localSeq++
+	capture.writePacket(tcpPacket(c.remote, c.local, c.remoteSeq, c.localSeq, tcpFlagACK, nil))
+	return c
+}
+
+func (c *capturedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n > 0 {
+		c.writeData(c.remote, c.local, &c.remoteSeq, c.localSeq, b[:n])
+	}
+	if errors.Is(err, io.EOF) && !c.remoteFinished {
+		c.remoteFinished = true
+		c.capture.writePacket(tcpPacket(c.remote, c.local, c.remoteSeq, c.localSeq, tcpFlagFIN|tcpFlagACK, nil))
+		c.remoteSeq++
+	}
+	return n, err
+}
+
+func (c *capturedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n > 0 {
+		c.writeData(c.local, c.remote, &c.localSeq, c.remoteSeq, b[:n])
+	}
+	return n, err
+}
+
+func (c *capturedConn) Close() error {
+	c.mu.Lock()
+	if !c.localFinished {
+		c.localFinished = true
+		c.capture.writePacket(tcpPacket(c.local, c.remote, c.localSeq, c.remoteSeq, tcpFlagFIN|tcpFlagACK, nil))
+		c.localSeq++
+	}
+	c.mu.Unlock()
+
+	return c.Conn.Close()
+}
+
+func (c *capturedConn) writeData(src pcapEndpoint, dst pcapEndpoint, seq *uint32, ack uint32, data []byte) {
+	for len(data) > 0 {
+		segment := data[:min(len(data), pcapMaxSegmentSize)]
+		c.capture.writePacket(tcpPacket(src, dst, *seq, ack, tcpFlagPSH|tcpFlagACK, segment))
+		*seq += uint32(len(segment))
+		data = data[len(segment):]
+	}
+}
+
+type capturedListener struct {
+	net.Listener
+	capture *pcapWriter
+}
+
+func (l *capturedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return newCapturedConn(conn, l.capture), nil
+}
+
+// captureListener records the connections accepted by the listener if the test case is being captured
+func captureListener(listener net.Listener, logger *logger.Logger) net.Listener {
+	capture := captureFor(logger)
+	if capture == nil {
+		return listener
+	}
+	return &capturedListener{Listener: listener, capture: capture}
+}
+
+type capturedPacketConn struct {
+	net.PacketConn
+	capture *pcapWriter
+}
+
+func (c *capturedPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if err == nil {
+		c.capture.writePacket(udpPacket(pcapEndpointFor(addr), pcapEndpointFor(c.LocalAddr()), b[:n]))
+	}
+	return n, addr, err
+}
+
+func (c *capturedPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(b, addr)
+	if err == nil {
+		c.capture.writePacket(udpPacket(pcapEndpointFor(c.LocalAddr()), pcapEndpointFor(addr), b[:n]))
+	}
+	return n, err
+}
+
+// capturePacketConn records the datagrams sent and received on conn if the test case is being captured
+func capturePacketConn(conn net.PacketConn, logger *logger.Logger) net.PacketConn {
+	capture := captureFor(logger)
+	if capture == nil {
+		return conn
+	}
+	return &capturedPacketConn{PacketConn: conn, capture: capture}
+}
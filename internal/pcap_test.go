@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCapturedConnWritesTCPStream(t *testing.T) {
+	capturePath := filepath.Join(t.TempDir(), "capture.pcapng")
+	capture, err := newPCAPWriter(capturePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := tcpConnPair(t)
+	defer client.Close()
+	captured := newCapturedConn(server, capture)
+
+	request := bytes.Repeat([]byte("abc"), 1000)
+	go func() {
+		client.Write(request)
+		client.Close()
+	}()
+
+	received, err := io.ReadAll(captured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, request) {
+		t.Fatalf("expected to read %d bytes, got %d", len(request), len(received))
+	}
+	captured.Write([]byte("response"))
+	captured.Close()
+	capture.Close()
+
+	packets := readPCAPNGPackets(t, capturePath)
+
+	var fromClient, fromServer []byte
+	var flags []byte
+	for _, packet := range packets {
+		if internetChecksum(packet[:20]) != 0 {
+			t.Errorf("invalid IPv4 header checksum in packet %x", packet[:20])
+		}
+		segment := packet[20:]
+		src, dst := pcapEndpoint{port: binary.BigEndian.Uint16(segment[0:2])}, pcapEndpoint{port: binary.BigEndian.Uint16(segment[2:4])}
+		copy(src.ip[:], packet[12:16])
+		copy(dst.ip[:], packet[16:20])
+		if transportChecksum(ipProtocolTCP, src, dst, segment) != 0 {
+			t.Errorf("invalid TCP checksum in packet %d", len(flags))
+		}
+
+		flags = append(flags, segment[13])
+		if int(src.port) == client.LocalAddr().(*net.TCPAddr).Port {
+			fromClient = append(fromClient, segment[20:]...)
+		} else {
+			fromServer = append(fromServer, segment[20:]...)
+		}
+	}
+
+	if !bytes.Equal(fromClient, request) {
+		t.Errorf("expected client payload of %d bytes, got %d", len(request), len(fromClient))
+	}
+	if string(fromServer) != "response" {
+		t.Errorf("expected server payload %q, got %q", "response", fromServer)
+	}
+
+	// The request is split into as many segments as reads were needed, so only check the ends of the stream
+	handshake := []byte{tcpFlagSYN, tcpFlagSYN | tcpFlagACK, tcpFlagACK}
+	teardown := []byte{tcpFlagFIN | tcpFlagACK, tcpFlagPSH | tcpFlagACK, tcpFlagFIN | tcpFlagACK}
+	if len(flags) < len(handshake)+len(teardown)+1 || !bytes.Equal(flags[:3], handshake) || !bytes.Equal(flags[len(flags)-3:], teardown) {
+		t.Fatalf("expected TCP flags to start with %v and end with %v, got %v", handshake, teardown, flags)
+	}
+	for _, f := range flags[3 : len(flags)-3] {
+		if f != tcpFlagPSH|tcpFlagACK {
+			t.Errorf("expected only data segments between handshake and teardown, got %v", flags)
+			break
+		}
+	}
+}
+
+// readPCAPNGPackets returns the data of every enhanced packet block in a pcapng file
+func readPCAPNGPackets(t *testing.T, path string) [][]byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var packets [][]byte
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatalf("truncated block: %x", data)
+		}
+		blockType := binary.LittleEndian.Uint32(data[0:4])
+		length := binary.LittleEndian.Uint32(data[4:8])
+		if length%4 != 0 || int(length) > len(data) || binary.LittleEndian.Uint32(data[length-4:length]) != length {
+			t.Fatalf("invalid block length %d", length)
+		}
+		if blockType == pcapngEnhancedPacket {
+			capturedLength := binary.LittleEndian.Uint32(data[20:24])
+			packets = append(packets, data[28:28+capturedLength])
+		}
+		data = data[length:]
+	}
+	return packets
+}
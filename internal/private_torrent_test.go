@@ -0,0 +1,47 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/codecrafters-io/tester-utils/logger"
+)
+
+func TestPrivateTorrentPeerOnlyOffersPEXToExtensionClients(t *testing.T) {
+	for _, testCase := range []struct {
+		reserved       [8]byte
+		expectedNextID messageID
+	}{
+		{reserved: [8]byte{}, expectedNextID: MsgUnchoke},
+		{reserved: [8]byte{0, 0, 0, 0, 0, 16, 0, 0}, expectedNextID: MsgExtended},
+	} {
+		client, server := tcpConnPair(t)
+		defer client.Close()
+
+		quietLogger := logger.GetQuietLogger("")
+		infoHash := [20]byte{1, 2, 3}
+		go handlePrivateTorrentPeer(make(chan string, 1))(server, PeerConnectionParams{
+			infoHash:              infoHash,
+			expectedReservedBytes: [][]byte{{0, 0, 0, 0, 0, 0, 0, 0}, {0, 0, 0, 0, 0, 16, 0, 0}},
+			myReservedBytes:       []byte{0, 0, 0, 0, 0, 16, 0, 1},
+			bitfield:              fullBitfield(1),
+			pieces:                [][]byte{{1}},
+			isPrivateTorrent:      true,
+			logger:                quietLogger,
+		})
+
+		if err := sendHandshake(client, testCase.reserved, infoHash, [20]byte{4}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readHandshake(client, quietLogger); err != nil {
+			t.Fatal(err)
+		}
+		if msg, err := readMessage(client, quietLogger); err != nil || msg.ID != MsgBitfield {
+			t.Fatalf("expected a bitfield, got %v (%v)", msg, err)
+		}
+
+		sendMessage(client, &Message{ID: MsgInterested})
+		if msg, err := readMessage(client, quietLogger); err != nil || msg.ID != testCase.expectedNextID {
+			t.Fatalf("expected message %d after the bitfield for reserved bytes %v, got %v (%v)", testCase.expectedNextID, testCase.reserved, msg, err)
+		}
+	}
+}
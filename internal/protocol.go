@@ -0,0 +1,226 @@
+// Declarative model of the peer wire protocol, used to catch messages the user's client sends out of order
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+type protocolFlag int
+
+const (
+	flagClientHandshake protocolFlag = iota
+	flagPeerHandshake
+	flagClientBitfield
+	flagClientStarted
+	flagClientInterested
+	flagClientUnchoked
+	flagClientExtensionHandshake
+	flagPeerExtensionHandshake
+)
+
+// protocolFlags lists every flag in the order they're printed, with their descriptions when set and unset
+var protocolFlags = []struct {
+	flag  protocolFlag
+	set   string
+	unset string
+}{
+	{flagClientHandshake, "client sent handshake", "client hasn't sent handshake"},
+	{flagPeerHandshake, "peer sent handshake", "peer hasn't sent handshake"},
+	{flagClientBitfield, "client sent bitfield", "client hasn't sent bitfield"},
+	{flagClientStarted, "client sent messages", "client hasn't sent messages"},
+	{flagClientInterested, "interested", "not interested"},
+	{flagClientUnchoked, "unchoked", "choked"},
+	{flagClientExtensionHandshake, "client sent extension handshake", "client hasn't sent extension handshake"},
+	{flagPeerExtensionHandshake, "peer sent extension handshake", "peer hasn't sent extension handshake"},
+}
+
+type protocolCondition struct {
+	flag   protocolFlag
+	want   bool
+	reason string
+}
+
+// protocolTransition describes one message: when it may be sent, and how it changes the state
+type protocolTransition struct {
+	direction transcriptDirection
+	event     string
+	requires  []protocolCondition
+	sets      []protocolFlag
+	clears    []protocolFlag
+}
+
+// Messages that don't depend on the state. Keep-alives are left out on purpose, they aren't counted as messages
+// for the bitfield check.
+var unrestrictedClientEvents = []string{"choke", "unchoke", "have", "piece", "cancel", "port", "hash request", "hashes", "hash reject"}
+
+var peerProtocolTransitions = buildPeerProtocolTransitions()
+
+func buildPeerProtocolTransitions() []protocolTransition {
+	transitions := []protocolTransition{
+		{
+			direction: directionReceived,
+			event:     "handshake",
+			requires:  []protocolCondition{{flagClientHandshake, false, "the handshake can only be sent once"}},
+			sets:      []protocolFlag{flagClientHandshake},
+		},
+		{
+			direction: directionReceived,
+			event:     "keep-alive",
+		},
+		{
+			direction: directionReceived,
+			event:     "bitfield",
+			requires: []protocolCondition{
+				{flagClientBitfield, false, "the bitfield can only be sent once"},
+				{flagClientStarted, false, "the bitfield can only be sent as the first message after the handshake"},
+			},
+			sets: []protocolFlag{flagClientBitfield, flagClientStarted},
+		},
+		{
+			direction: directionReceived,
+			event:     "interested",
+			sets:      []protocolFlag{flagClientInterested, flagClientStarted},
+		},
+		{
+			direction: directionReceived,
+			event:     "not interested",
+			sets:      []protocolFlag{flagClientStarted},
+			clears:    []protocolFlag{flagClientInterested},
+		},
+		{
+			direction: directionReceived,
+			event:     "request",
+			requires: []protocolCondition{
+				{flagClientInterested, true, "send an interested message before requesting pieces"},
+				{flagClientUnchoked, true, "wait for an unchoke message before requesting pieces"},
+			},
+			sets: []protocolFlag{flagClientStarted},
+		},
+		{
+			// The extension handshake may come before the bitfield, so it doesn't count as a message
+			direction: directionReceived,
+			event:     "extension handshake",
+			sets:      []protocolFlag{flagClientExtensionHandshake},
+		},
+		{
+			direction: directionReceived,
+			event:     "extension message",
+			requires: []protocolCondition{
+				{flagClientExtensionHandshake, true, "send an extension handshake before any other extension message"},
+				{flagPeerExtensionHandshake, true, "wait for the peer's extension handshake, it tells which extension message IDs the peer uses"},
+			},
+			sets: []protocolFlag{flagClientStarted},
+		},
+		{
+			direction: directionSent,
+			event:     "handshake",
+			sets:      []protocolFlag{flagPeerHandshake},
+		},
+		{
+			direction: directionSent,
+			event:     "unchoke",
+			sets:      []protocolFlag{flagClientUnchoked},
+		},
+		{
+			direction: directionSent,
+			event:     "choke",
+			clears:    []protocolFlag{flagClientUnchoked},
+		},
+		{
+			direction: directionSent,
+			event:     "extension handshake",
+			sets:      []protocolFlag{flagPeerExtensionHandshake},
+		},
+	}
+
+	for _, event := range unrestrictedClientEvents {
+		transitions = append(transitions, protocolTransition{
+			direction: directionReceived,
+			event:     event,
+			sets:      []protocolFlag{flagClientStarted},
+		})
+	}
+	return transitions
+}
+
+// protocolEvent names a frame the way peerProtocolTransitions does. msg is nil for handshakes and keep-alives.
+func protocolEvent(kind string, msg *Message) string {
+	if msg != nil && msg.ID == MsgExtended && len(msg.Payload) > 0 {
+		if msg.Payload[0] == HandshakeExtendedID {
+			return "extension handshake"
+		}
+		return "extension message"
+	}
+	return kind
+}
+
+// protocolStateMachine follows a single connection, and keeps the first violation
+type protocolStateMachine struct {
+	state     map[protocolFlag]bool
+	violation error
+}
+
+func newProtocolStateMachine() *protocolStateMachine {
+	return &protocolStateMachine{state: make(map[protocolFlag]bool)}
+}
+
+// observe applies a frame to the state. Events without a transition, like unknown message IDs, are ignored.
+func (m *protocolStateMachine) observe(direction transcriptDirection, event string) {
+	transition := findProtocolTransition(direction, event)
+	if transition == nil {
+		return
+	}
+
+	if failed := m.failedCondition(transition); failed != nil && m.violation == nil {
+		m.violation = fmt.Errorf("received %s message in state [%s]: %s. Messages allowed in this state: %s",
+			event, m.describeState(), failed.reason, strings.Join(m.allowedEvents(direction), ", "))
+	}
+
+	for _, flag := range transition.sets {
+		m.state[flag] = true
+	}
+	for _, flag := range transition.clears {
+		m.state[flag] = false
+	}
+}
+
+func findProtocolTransition(direction transcriptDirection, event string) *protocolTransition {
+	for i := range peerProtocolTransitions {
+		if peerProtocolTransitions[i].direction == direction && peerProtocolTransitions[i].event == event {
+			return &peerProtocolTransitions[i]
+		}
+	}
+	return nil
+}
+
+func (m *protocolStateMachine) failedCondition(transition *protocolTransition) *protocolCondition {
+	for i, condition := range transition.requires {
+		if m.state[condition.flag] != condition.want {
+			return &transition.requires[i]
+		}
+	}
+	return nil
+}
+
+func (m *protocolStateMachine) describeState() string {
+	var descriptions []string
+	for _, f := range protocolFlags {
+		if m.state[f.flag] {
+			descriptions = append(descriptions, f.set)
+		} else {
+			descriptions = append(descriptions, f.unset)
+		}
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+func (m *protocolStateMachine) allowedEvents(direction transcriptDirection) []string {
+	var events []string
+	for i, transition := range peerProtocolTransitions {
+		if transition.direction == direction && m.failedCondition(&peerProtocolTransitions[i]) == nil {
+			events = append(events, transition.event)
+		}
+	}
+	return events
+}
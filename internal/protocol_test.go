@@ -0,0 +1,177 @@
+package internal
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/tester-utils/logger"
+)
+
+type protocolStep struct {
+	direction transcriptDirection
+	event     string
+}
+
+func runProtocolSteps(steps []protocolStep) error {
+	machine := newProtocolStateMachine()
+	for _, step := range steps {
+		machine.observe(step.direction, step.event)
+	}
+	return machine.violation
+}
+
+func TestProtocolStateMachineAcceptsValidExchanges(t *testing.T) {
+	exchanges := map[string][]protocolStep{
+		"download": {
+			{directionReceived, "handshake"},
+			{directionSent, "handshake"},
+			{directionSent, "bitfield"},
+			{directionReceived, "bitfield"},
+			{directionReceived, "interested"},
+			{directionSent, "unchoke"},
+			{directionReceived, "request"},
+			{directionSent, "piece"},
+			{directionReceived, "keep-alive"},
+			{directionReceived, "request"},
+		},
+		"metadata": {
+			{directionReceived, "handshake"},
+			{directionSent, "handshake"},
+			{directionSent, "bitfield"},
+			{directionSent, "extension handshake"},
+			{directionReceived, "extension handshake"},
+			{directionReceived, "bitfield"},
+			{directionReceived, "extension message"},
+		},
+	}
+
+	for name, steps := range exchanges {
+		if err := runProtocolSteps(steps); err != nil {
+			t.Errorf("%s: unexpected violation: %v", name, err)
+		}
+	}
+}
+
+func TestProtocolStateMachineReportsViolations(t *testing.T) {
+	handshakes := []protocolStep{{directionReceived, "handshake"}, {directionSent, "handshake"}}
+
+	tests := []struct {
+		name     string
+		steps    []protocolStep
+		expected string
+	}{
+		{
+			name:     "request before interested",
+			steps:    []protocolStep{{directionSent, "unchoke"}, {directionReceived, "request"}},
+			expected: "received request message in state [client sent handshake, peer sent handshake, client hasn't sent bitfield, client hasn't sent messages, not interested, unchoked,",
+		},
+		{
+			name:     "request while choked",
+			steps:    []protocolStep{{directionReceived, "interested"}, {directionReceived, "request"}},
+			expected: "wait for an unchoke message before requesting pieces",
+		},
+		{
+			name:     "extended message before extension handshake",
+			steps:    []protocolStep{{directionSent, "extension handshake"}, {directionReceived, "extension message"}},
+			expected: "send an extension handshake before any other extension message. Messages allowed in this state: keep-alive, bitfield, interested, not interested, extension handshake, choke,",
+		},
+		{
+			name:     "extended message before the peer's extension handshake",
+			steps:    []protocolStep{{directionReceived, "extension handshake"}, {directionReceived, "extension message"}},
+			expected: "wait for the peer's extension handshake",
+		},
+		{
+			name:     "bitfield sent twice",
+			steps:    []protocolStep{{directionReceived, "bitfield"}, {directionReceived, "bitfield"}},
+			expected: "the bitfield can only be sent once",
+		},
+		{
+			name:     "bitfield after other messages",
+			steps:    []protocolStep{{directionReceived, "interested"}, {directionReceived, "bitfield"}},
+			expected: "the bitfield can only be sent as the first message after the handshake",
+		},
+	}
+
+	for _, test := range tests {
+		err := runProtocolSteps(append(append([]protocolStep{}, handshakes...), test.steps...))
+		if err == nil {
+			t.Errorf("%s: expected a violation", test.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%s: expected violation to contain %q, got %q", test.name, test.expected, err)
+		}
+	}
+}
+
+func TestReadMessageReportsProtocolViolations(t *testing.T) {
+	client, server := tcpConnPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	recorder := newTranscriptConn(server)
+	quietLogger := logger.GetQuietLogger("")
+
+	go func() {
+		sendHandshake(client, [8]byte{}, [20]byte{1}, [20]byte{2})
+		client.Write((&Message{ID: MsgInterested}).Serialize())
+		client.Write((&Message{ID: MsgRequest, Payload: make([]byte, 12)}).Serialize())
+	}()
+
+	if _, err := readHandshake(recorder, quietLogger); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readMessage(recorder, quietLogger); err != nil {
+		t.Fatalf("expected interested message to be accepted, got %v", err)
+	}
+	_, err := readMessage(recorder, quietLogger)
+	if err == nil || !strings.Contains(err.Error(), "wait for an unchoke message") {
+		t.Fatalf("expected request while choked to be reported, got %v", err)
+	}
+}
+
+func TestReadMessageReportsProtocolViolationsThroughWrappedConns(t *testing.T) {
+	client, server := tcpConnPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	quietLogger := logger.GetQuietLogger("")
+	conn := &coalescingConn{Conn: newFaultyConn(newTranscriptConn(server), "none", faultProfile{}, 0, quietLogger)}
+
+	go func() {
+		sendHandshake(client, [8]byte{}, [20]byte{1}, [20]byte{2})
+		client.Write((&Message{ID: MsgRequest, Payload: make([]byte, 12)}).Serialize())
+	}()
+
+	if _, err := readHandshake(conn, quietLogger); err != nil {
+		t.Fatal(err)
+	}
+	_, err := readMessage(conn, quietLogger)
+	if err == nil || !strings.Contains(err.Error(), "send an interested message before requesting pieces") {
+		t.Fatalf("expected request before interested to be reported, got %v", err)
+	}
+}
+
+func TestRecordedConnectionReportsProtocolViolations(t *testing.T) {
+	context, logger := registerTestCaseContext(t)
+	client, server := tcpConnPair(t)
+	defer client.Close()
+
+	go func() {
+		sendHandshake(client, [8]byte{}, [20]byte{1}, [20]byte{2})
+		client.Write((&Message{ID: MsgRequest, Payload: make([]byte, 12)}).Serialize())
+	}()
+
+	// Reads the messages without readMessage, so the order isn't checked while the handler runs
+	handleRecordedConnection(server, PeerConnectionParams{logger: logger}, func(conn net.Conn, params PeerConnectionParams) error {
+		_, err := io.ReadFull(conn, make([]byte, 68+4+13))
+		return err
+	})
+
+	err := context.protocolViolation()
+	if err == nil || !strings.Contains(err.Error(), "send an interested message before requesting pieces") {
+		t.Fatalf("expected request before interested to be reported, got %v", err)
+	}
+}
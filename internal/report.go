@@ -0,0 +1,243 @@
+// Machine readable report of a test run, for running the tester in batch over many implementations
+package internal
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/tester-utils/executable"
+	"github.com/codecrafters-io/tester-utils/test_case_harness"
+)
+
+// reportPathEnvVar names the file the report is written to. Paths ending in .xml get a JUnit report, others JSON.
+const reportPathEnvVar = "CODECRAFTERS_REPORT_PATH"
+
+// Outputs longer than this are truncated, stress stages print hundreds of kilobytes
+const maxReportOutputLength = 64 * 1024
+
+type testReport struct {
+	mu     sync.Mutex
+	path   string
+	Stages []*stageReport `json:"stages"`
+}
+
+type stageReport struct {
+	report    *testReport
+	startedAt time.Time
+	finished  bool
+
+	Slug            string          `json:"slug"`
+	Status          string          `json:"status"`
+	DurationSeconds float64         `json:"duration_seconds"`
+	Error           string          `json:"error,omitempty"`
+	Commands        []commandReport `json:"commands"`
+	Metrics         *stageMetrics   `json:"metrics,omitempty"`
+	Regressions     []string        `json:"regressions,omitempty"`
+}
+
+type commandReport struct {
+	Args            []string `json:"args"`
+	Stdin           string   `json:"stdin,omitempty"`
+	ExitCode        int      `json:"exit_code"`
+	Stdout          string   `json:"stdout"`
+	Stderr          string   `json:"stderr"`
+	DurationSeconds float64  `json:"duration_seconds"`
+	Error           string   `json:"error,omitempty"`
+}
+
+func newTestReport(path string) *testReport {
+	return &testReport{path: path}
+}
+
+func (r *testReport) startStage(slug string) *stageReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stage := &stageReport{report: r, startedAt: time.Now(), Slug: slug, Status: "running", Commands: []commandReport{}}
+	r.Stages = append(r.Stages, stage)
+	return stage
+}
+
+// finish records the result of the stage. Only the first result counts, so a stage that timed out stays failed
+// even if its test function returns later.
+func (s *stageReport) finish(err error) {
+	s.report.mu.Lock()
+	defer s.report.mu.Unlock()
+
+	if s.finished {
+		return
+	}
+	s.finished = true
+	s.DurationSeconds = time.Since(s.startedAt).Seconds()
+	if err != nil {
+		s.Status = "failed"
+		s.Error = err.Error()
+	} else {
+		s.Status = "passed"
+	}
+}
+
+func (s *stageReport) addCommand(command commandReport) {
+	s.report.mu.Lock()
+	defer s.report.mu.Unlock()
+
+	if !s.finished {
+		s.Commands = append(s.Commands, command)
+	}
+}
+
+func (s *stageReport) setMetrics(metrics stageMetrics, regressions []string) {
+	s.report.mu.Lock()
+	defer s.report.mu.Unlock()
+
+	s.Metrics = &metrics
+	s.Regressions = regressions
+}
+
+func (r *testReport) write() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var contents []byte
+	var err error
+	if strings.HasSuffix(r.path, ".xml") {
+		contents, err = r.junitXML()
+	} else {
+		contents, err = json.MarshalIndent(r, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, append(contents, '\n'), 0644)
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func (r *testReport) junitXML() ([]byte, error) {
+	suite := junitTestSuite{Name: "bittorrent-tester"}
+	var total float64
+
+	for _, stage := range r.Stages {
+		testCase := junitTestCase{
+			Name:      stage.Slug,
+			ClassName: "stages",
+			Time:      fmt.Sprintf("%.3f", stage.DurationSeconds),
+			SystemOut: stage.describeCommands(),
+		}
+		if stage.Status != "passed" {
+			testCase.Failure = &junitFailure{Message: stage.Error, Text: stage.Error}
+			suite.Failures++
+		}
+		suite.Cases = append(suite.Cases, testCase)
+		suite.Tests++
+		total += stage.DurationSeconds
+	}
+	suite.Time = fmt.Sprintf("%.3f", total)
+
+	encoded, err := xml.MarshalIndent(junitTestSuites{Suites: []junitTestSuite{suite}}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), encoded...), nil
+}
+
+// describeCommands renders the commands of a stage the way they'd be typed, followed by their outputs
+func (s *stageReport) describeCommands() string {
+	var description strings.Builder
+	for _, command := range s.Commands {
+		fmt.Fprintf(&description, "$ %s\n", strings.Join(command.Args, " "))
+		if command.Stdout != "" {
+			fmt.Fprintf(&description, "stdout:\n%s\n", strings.TrimSuffix(command.Stdout, "\n"))
+		}
+		if command.Stderr != "" {
+			fmt.Fprintf(&description, "stderr:\n%s\n", strings.TrimSuffix(command.Stderr, "\n"))
+		}
+		if command.Error != "" {
+			fmt.Fprintf(&description, "error: %s\n\n", command.Error)
+		} else {
+			fmt.Fprintf(&description, "exit code: %d\n\n", command.ExitCode)
+		}
+	}
+	return description.String()
+}
+
+func truncateForReport(b []byte) string {
+	if len(b) <= maxReportOutputLength {
+		return string(b)
+	}
+	return fmt.Sprintf("%s... (%d more bytes)", b[:maxReportOutputLength], len(b)-maxReportOutputLength)
+}
+
+// recordingExecutable runs the user's program and records every command in the test case's context, if there is one
+type recordingExecutable struct {
+	*executable.Executable
+	context *testCaseContext
+}
+
+func newRecordingExecutable(harness *test_case_harness.TestCaseHarness) *recordingExecutable {
+	return &recordingExecutable{Executable: harness.Executable, context: testCaseContextFor(harness.Logger)}
+}
+
+func (e *recordingExecutable) Run(args ...string) (executable.ExecutableResult, error) {
+	return e.record(nil, args, func() (executable.ExecutableResult, error) {
+		return e.Executable.Run(args...)
+	})
+}
+
+func (e *recordingExecutable) RunWithStdin(stdin []byte, args ...string) (executable.ExecutableResult, error) {
+	return e.record(stdin, args, func() (executable.ExecutableResult, error) {
+		return e.Executable.RunWithStdin(stdin, args...)
+	})
+}
+
+func (e *recordingExecutable) record(stdin []byte, args []string, run func() (executable.ExecutableResult, error)) (executable.ExecutableResult, error) {
+	startedAt := time.Now()
+	result, err := run()
+	if e.context == nil {
+		return result, err
+	}
+
+	command := commandReport{
+		Args:            append([]string{"./" + path.Base(e.Path)}, args...),
+		Stdin:           truncateForReport(stdin),
+		ExitCode:        result.ExitCode,
+		Stdout:          truncateForReport(result.Stdout),
+		Stderr:          truncateForReport(result.Stderr),
+		DurationSeconds: time.Since(startedAt).Seconds(),
+	}
+	if err != nil {
+		command.Error = err.Error()
+	}
+	e.context.addCommand(command)
+	return result, err
+}
@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStageReportKeepsFirstResult(t *testing.T) {
+	report := newTestReport("")
+	stage := report.startStage("ab1")
+	stage.addCommand(commandReport{Args: []string{"./your_program.sh", "decode", "5:hello"}})
+
+	stage.finish(errors.New("timed out, test exceeded 10 seconds"))
+	stage.finish(nil)
+	stage.addCommand(commandReport{Args: []string{"./your_program.sh", "decode", "late"}})
+
+	if stage.Status != "failed" || stage.Error != "timed out, test exceeded 10 seconds" {
+		t.Errorf("expected stage to stay failed after timing out, got status %q and error %q", stage.Status, stage.Error)
+	}
+	if len(stage.Commands) != 1 {
+		t.Errorf("expected commands after the stage finished to be dropped, got %d commands", len(stage.Commands))
+	}
+}
+
+func TestTestReportFormats(t *testing.T) {
+	directory := t.TempDir()
+	for _, name := range []string{"report.json", "report.xml"} {
+		report := newTestReport(filepath.Join(directory, name))
+		passed := report.startStage("ab1")
+		passed.addCommand(commandReport{Args: []string{"./your_program.sh", "decode", "i5e"}, Stdout: "5\n"})
+		passed.finish(nil)
+		failed := report.startStage("cd2")
+		failed.addCommand(commandReport{Args: []string{"./your_program.sh", "decode", "\x00"}, Stdout: "\xff\n", ExitCode: 1})
+		failed.finish(errors.New("Expected exit code 0, got 1"))
+
+		if err := report.write(); err != nil {
+			t.Fatal(err)
+		}
+		contents, err := os.ReadFile(report.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if name == "report.json" {
+			var decoded struct {
+				Stages []struct {
+					Slug     string
+					Status   string
+					Error    string
+					Commands []struct {
+						Args     []string
+						ExitCode int `json:"exit_code"`
+					}
+				}
+			}
+			if err := json.Unmarshal(contents, &decoded); err != nil {
+				t.Fatalf("invalid JSON report: %v", err)
+			}
+			if len(decoded.Stages) != 2 || decoded.Stages[0].Status != "passed" || decoded.Stages[1].Status != "failed" || decoded.Stages[1].Commands[0].ExitCode != 1 {
+				t.Errorf("unexpected JSON report: %s", contents)
+			}
+		} else {
+			var decoded junitTestSuites
+			if err := xml.Unmarshal(contents, &decoded); err != nil {
+				t.Fatalf("invalid JUnit report: %v", err)
+			}
+			suite := decoded.Suites[0]
+			if suite.Tests != 2 || suite.Failures != 1 || suite.Cases[0].Failure != nil || suite.Cases[1].Failure == nil {
+				t.Errorf("unexpected JUnit report: %s", contents)
+			}
+		}
+	}
+}
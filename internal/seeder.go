@@ -0,0 +1,108 @@
+// Emulated peer that seeds the pieces of a file
+package internal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	logger "github.com/codecrafters-io/tester-utils/logger"
+)
+
+const maxBlockSize = 16 * 1024
+
+// MessageHandler handles messages servePieces doesn't know about. It returns false if the message wasn't handled.
+type MessageHandler func(conn net.Conn, msg *Message) (bool, error)
+
+func splitIntoPieces(content []byte, pieceLengthBytes int) [][]byte {
+	var pieces [][]byte
+	for start := 0; start < len(content); start += pieceLengthBytes {
+		pieces = append(pieces, content[start:min(start+pieceLengthBytes, len(content))])
+	}
+	return pieces
+}
+
+func fullBitfield(pieceCount int) []byte {
+	bitfield := make([]byte, (pieceCount+7)/8)
+	for i := 0; i < pieceCount; i++ {
+		bitfield[i/8] |= 1 << (7 - i%8)
+	}
+	return bitfield
+}
+
+func sendMessage(conn net.Conn, msg *Message) error {
+	_, err := conn.Write(msg.Serialize())
+	return err
+}
+
+// servePieces answers interested and request messages until the other side closes the connection
+func servePieces(conn net.Conn, pieces [][]byte, logger *logger.Logger, handleOther MessageHandler) (err error) {
+	defer logOnExit(logger, &err)
+
+	for {
+		msg, err := readMessage(conn, logger)
+		// The connection is closed by us when the test case ends, which can happen before the client's EOF is read
+		if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading message: %v", err)
+		}
+
+		if msg == nil {
+			continue // keep-alive
+		}
+
+		switch msg.ID {
+		case MsgInterested:
+			logger.Debugln("Received interested message, sending unchoke")
+			if err := sendMessage(conn, &Message{ID: MsgUnchoke}); err != nil {
+				return err
+			}
+		case MsgRequest:
+			if err := servePieceRequest(conn, msg, pieces, logger); err != nil {
+				return err
+			}
+		case MsgNotInterested, MsgHave, MsgBitfield, MsgCancel:
+			// Nothing to do, we already have every piece
+		default:
+			handled := false
+			if handleOther != nil {
+				if handled, err = handleOther(conn, msg); err != nil {
+					return err
+				}
+			}
+			if !handled {
+				logger.Debugf("Ignoring unexpected message with id: %d", msg.ID)
+			}
+		}
+	}
+}
+
+func servePieceRequest(conn net.Conn, msg *Message, pieces [][]byte, logger *logger.Logger) error {
+	if len(msg.Payload) != 12 {
+		return fmt.Errorf("request message payload needs to be 12 bytes (index, begin, length), got %d bytes", len(msg.Payload))
+	}
+
+	index := int(binary.BigEndian.Uint32(msg.Payload[0:4]))
+	begin := int(binary.BigEndian.Uint32(msg.Payload[4:8]))
+	length := int(binary.BigEndian.Uint32(msg.Payload[8:12]))
+	logger.Debugf("Received request for piece %d, begin: %d, length: %d", index, begin, length)
+
+	if index >= len(pieces) {
+		return fmt.Errorf("requested piece index %d, but there are only %d pieces", index, len(pieces))
+	}
+	if length > maxBlockSize {
+		return fmt.Errorf("requested block length %d is larger than the maximum block size of %d bytes", length, maxBlockSize)
+	}
+	if begin+length > len(pieces[index]) {
+		return fmt.Errorf("requested bytes %d-%d of piece %d, but the piece is only %d bytes long. Note that the last piece can be shorter than the piece length", begin, begin+length-1, index, len(pieces[index]))
+	}
+
+	payload := make([]byte, 8+length)
+	copy(payload[0:8], msg.Payload[0:8])
+	copy(payload[8:], pieces[index][begin:begin+length])
+	return sendMessage(conn, &Message{ID: MsgPiece, Payload: payload})
+}
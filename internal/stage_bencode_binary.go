@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/codecrafters-io/tester-utils/random"
+	"github.com/codecrafters-io/tester-utils/test_case_harness"
+)
+
+var multibyteSuffixes = []string{"é", "ü", "日本語", "🚀", "Ωμέγα"}
+
+func testBencodeBinaryStrings(stageHarness *test_case_harness.TestCaseHarness) error {
+	logger := stageHarness.Logger
+	executable := newRecordingExecutable(stageHarness)
+
+	// Multibyte UTF-8: the length prefix counts bytes, not characters
+	utf8String := random.RandomWord() + random.RandomElementFromArray(multibyteSuffixes)
+	utf8Encoded := fmt.Sprintf("%d:%s", len(utf8String), utf8String)
+	expected, err := json.Marshal(utf8String)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Running ./%s decode %s", path.Base(executable.Path), utf8Encoded)
+	logger.Infof("Expected output: %s", expected)
+	result, err := executable.Run("decode", utf8Encoded)
+	if err != nil {
+		return err
+	}
+
+	if err = assertExitCode(result, 0); err != nil {
+		return err
+	}
+
+	if err = assertStdoutJSON(result, utf8String); err != nil {
+		logger.Errorf("The length prefix %d is the number of bytes in the string, which is more than the number of characters", len(utf8String))
+		return err
+	}
+
+	// Embedded NULs can't be passed as arguments, so the value is sent over stdin
+	nulString := random.RandomWord() + "\x00" + random.RandomWord()
+	nulEncoded := fmt.Sprintf("%d:%s", len(nulString), nulString)
+	expected, err = json.Marshal(nulString)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Running ./%s decode - with %q as stdin", path.Base(executable.Path), nulEncoded)
+	logger.Infof("Expected output: %s", expected)
+	result, err = executable.RunWithStdin([]byte(nulEncoded), "decode", "-")
+	if err != nil {
+		return err
+	}
+
+	if err = assertExitCode(result, 0); err != nil {
+		return err
+	}
+
+	if err = assertStdoutJSON(result, nulString); err != nil {
+		return err
+	}
+
+	// Bytes that aren't valid UTF-8, like a SHA-1 hash, are printed as lowercase hex. They can contain NULs too, so
+	// they're sent over stdin as well.
+	binaryString := randomBytes(20)
+	binaryString[0] = 0xff
+	randomWord := random.RandomWord()
+	binaryEncoded := fmt.Sprintf("l%d:%s%d:%se", len(randomWord), randomWord, len(binaryString), binaryString)
+	binaryExpected := []interface{}{randomWord, hex.EncodeToString(binaryString)}
+	expected, err = json.Marshal(binaryExpected)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Running ./%s decode - with %q as stdin", path.Base(executable.Path), binaryEncoded)
+	logger.Infof("Expected output: %s", expected)
+	result, err = executable.RunWithStdin([]byte(binaryEncoded), "decode", "-")
+	if err != nil {
+		return err
+	}
+
+	if err = assertExitCode(result, 0); err != nil {
+		return err
+	}
+
+	if err = assertStdoutJSON(result, binaryExpected); err != nil {
+		logger.Errorln("Strings that aren't valid UTF-8 need to be printed as a JSON string containing their bytes in lowercase hex")
+		return err
+	}
+
+	return nil
+}
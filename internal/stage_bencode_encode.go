@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/codecrafters-io/tester-utils/random"
+	"github.com/codecrafters-io/tester-utils/test_case_harness"
+	"github.com/jackpal/bencode-go"
+)
+
+type BencodeEncodeTest struct {
+	// JSON passed to the encode command, defaults to the JSON encoding of value
+	input string
+	value interface{}
+}
+
+func testBencodeEncode(stageHarness *test_case_harness.TestCaseHarness) error {
+	logger := stageHarness.Logger
+	executable := newRecordingExecutable(stageHarness)
+
+	zebra, apple := random.RandomInt(0, 1000), random.RandomWord()
+	tests := []BencodeEncodeTest{
+		{value: random.RandomWord()},
+		{value: randomBencodeInt()},
+		{value: []interface{}{random.RandomWord(), random.RandomInt(0, 1000)}},
+		// Keys are out of order in the JSON input, but need to be sorted in the output
+		{
+			input: fmt.Sprintf("{\"zebra\":%d,\"apple\":%q}", zebra, apple),
+			value: map[string]interface{}{"zebra": zebra, "apple": apple},
+		},
+	}
+	for range 4 {
+		tests = append(tests, BencodeEncodeTest{value: randomBencodeValue(3, true)})
+	}
+
+	for _, t := range tests {
+		input := t.input
+		if input == "" {
+			encoded, err := json.Marshal(t.value)
+			if err != nil {
+				return err
+			}
+			input = string(encoded)
+		}
+		expected := encodeBencode(t.value)
+
+		logger.Infof("Running ./%s encode '%s'", path.Base(executable.Path), input)
+		logger.Infof("Expected output: %s", expected)
+		result, err := executable.Run("encode", input)
+		if err != nil {
+			return err
+		}
+
+		if err = assertExitCode(result, 0); err != nil {
+			return err
+		}
+
+		if err = assertBencodeRoundTrip(result.Stdout, t.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func assertBencodeRoundTrip(stdout []byte, value interface{}) error {
+	expected := encodeBencode(value)
+	actual := bytes.TrimSuffix(stdout, []byte("\n"))
+	if bytes.Equal(actual, expected) {
+		return nil
+	}
+
+	decoded, err := bencode.Decode(bytes.NewReader(actual))
+	if err != nil {
+		return fmt.Errorf("Expected %q as stdout, got: %q, which isn't valid bencode (%v)", expected, actual, err)
+	}
+
+	converted, err := fromDecodedBencode(decoded)
+	if err == nil && bytes.Equal(encodeBencode(converted), expected) {
+		return fmt.Errorf("Expected %q as stdout, got: %q. It decodes to the right value, but isn't canonical. Dictionary keys need to be sorted and integers can't have leading zeros", expected, actual)
+	}
+
+	return fmt.Errorf("Expected %q as stdout, got: %q", expected, actual)
+}
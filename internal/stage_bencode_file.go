@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/codecrafters-io/tester-utils/random"
+	"github.com/codecrafters-io/tester-utils/test_case_harness"
+	"github.com/jackpal/bencode-go"
+)
+
+func testBencodeDecodeFile(stageHarness *test_case_harness.TestCaseHarness) error {
+	logger := stageHarness.Logger
+	executable := newRecordingExecutable(stageHarness)
+
+	tempDir, err := os.MkdirTemp("", "torrents")
+	if err != nil {
+		return err
+	}
+
+	torrent := randomTorrent()
+	if err := copyTorrent(tempDir, torrent.filename); err != nil {
+		logger.Errorln("Couldn't copy torrent file")
+		return err
+	}
+
+	torrentPath := path.Join(tempDir, torrent.filename)
+	expected, err := expectedDecodeOutput(torrentPath)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Running ./%s decode %s", path.Base(executable.Path), torrentPath)
+	result, err := executable.Run("decode", torrentPath)
+	if err != nil {
+		return err
+	}
+
+	if err = assertExitCode(result, 0); err != nil {
+		return err
+	}
+
+	if err = assertStdoutJSON(result, expected); err != nil {
+		logger.Errorln("Binary fields like pieces aren't valid UTF-8, they need to be printed as lowercase hex")
+		return err
+	}
+
+	logger.Successln("✓ Decoded torrent file correctly.")
+
+	pieceLengthBytes := 16 * 1024
+	content := randomBytes(random.RandomInt(2*pieceLengthBytes, 5*pieceLengthBytes))
+	generated := TorrentFile{
+		Announce: "http://bittorrent-test-tracker.codecrafters.io/announce",
+		Info: TorrentFileInfo{
+			Name:        fmt.Sprintf("%s.bin", random.RandomWord()),
+			Length:      len(content),
+			Pieces:      createPiecesStrFromBytes(content, pieceLengthBytes),
+			PieceLength: pieceLengthBytes,
+		},
+	}
+
+	generatedPath := path.Join(tempDir, "generated.torrent")
+	if _, err := generated.writeToFile(generatedPath); err != nil {
+		logger.Errorf("Error writing torrent file: %s", err)
+		return err
+	}
+
+	generatedBytes, err := os.ReadFile(generatedPath)
+	if err != nil {
+		return err
+	}
+	expected, err = expectedDecodeOutput(generatedPath)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Running ./%s decode - with the contents of %s as stdin", path.Base(executable.Path), generatedPath)
+	result, err = executable.RunWithStdin(generatedBytes, "decode", "-")
+	if err != nil {
+		return err
+	}
+
+	if err = assertExitCode(result, 0); err != nil {
+		return err
+	}
+
+	if err = assertStdoutJSON(result, expected); err != nil {
+		return err
+	}
+
+	logger.Successln("✓ Decoded torrent from stdin correctly.")
+
+	return nil
+}
+
+func expectedDecodeOutput(filePath string) (interface{}, error) {
+	contents, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := bencode.Decode(bytes.NewReader(contents))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s: %v", filePath, err)
+	}
+
+	converted, err := fromDecodedBencode(decoded)
+	if err != nil {
+		return nil, err
+	}
+
+	return hexEncodeBinaryStrings(converted), nil
+}
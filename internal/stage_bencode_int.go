@@ -11,10 +11,10 @@ import (
 
 func testBencodeInt(stageHarness *test_case_harness.TestCaseHarness) error {
 	logger := stageHarness.Logger
-	executable := stageHarness.Executable
+	executable := newRecordingExecutable(stageHarness)
 
-	randomNumber := fmt.Sprintf("%d", random.RandomInt(0, 2147483647))
-	randomNumberEncoded := fmt.Sprintf("i%se", randomNumber)
+	randomNumber := random.RandomInt(0, 2147483647)
+	randomNumberEncoded := fmt.Sprintf("i%de", randomNumber)
 	logger.Infof("Running ./%s decode %s", path.Base(executable.Path), randomNumberEncoded)
 	result, err := executable.Run("decode", randomNumberEncoded)
 	if err != nil {
@@ -25,17 +25,16 @@ func testBencodeInt(stageHarness *test_case_harness.TestCaseHarness) error {
 		return err
 	}
 
-	expected := randomNumber + "\n"
-	if err = assertStdout(result, expected); err != nil {
+	if err = assertStdoutJSON(result, randomNumber); err != nil {
 		actual := string(result.Stdout)
-		if strings.Contains(actual, "\""+randomNumber+"\"") {
+		if strings.Contains(actual, fmt.Sprintf("\"%d\"", randomNumber)) {
 			logger.Errorln("You need to print the number without quotes")
 		}
 		return err
 	}
 
-	largeNumber := "4294967300"
-	largeNumberEncoded := fmt.Sprintf("i%se", largeNumber)
+	largeNumber := 4294967300
+	largeNumberEncoded := fmt.Sprintf("i%de", largeNumber)
 	logger.Infof("Running ./%s decode %s", path.Base(executable.Path), largeNumberEncoded)
 	result, err = executable.Run("decode", largeNumberEncoded)
 	if err != nil {
@@ -46,8 +45,7 @@ func testBencodeInt(stageHarness *test_case_harness.TestCaseHarness) error {
 		return err
 	}
 
-	expected = largeNumber + "\n"
-	if err = assertStdout(result, expected); err != nil {
+	if err = assertStdoutJSON(result, largeNumber); err != nil {
 		return err
 	}
 
@@ -61,8 +59,7 @@ func testBencodeInt(stageHarness *test_case_harness.TestCaseHarness) error {
 		return err
 	}
 
-	expected = "-52" + "\n"
-	if err = assertStdout(result, expected); err != nil {
+	if err = assertStdoutJSON(result, -52); err != nil {
 		return err
 	}
 
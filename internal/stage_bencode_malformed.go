@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"path"
+
+	"github.com/codecrafters-io/tester-utils/random"
+	"github.com/codecrafters-io/tester-utils/test_case_harness"
+)
+
+type MalformedBencodeTest struct {
+	encoded string
+	reason  string
+}
+
+var malformedBencodeTests = []MalformedBencodeTest{
+	{encoded: "i03e", reason: "integers can't have leading zeros"},
+	{encoded: "i-0e", reason: "negative zero isn't a valid integer"},
+	{encoded: "ie", reason: "integers need at least one digit"},
+	{encoded: "i12", reason: "integers need to end with 'e'"},
+	{encoded: "i1.5e", reason: "integers can't have a fractional part"},
+	{encoded: "l5:helloi52e", reason: "lists need to end with 'e'"},
+	{encoded: "lli4ee", reason: "the outer list isn't terminated"},
+	{encoded: "d3:foo3:bar", reason: "dictionaries need to end with 'e'"},
+	{encoded: "10:hello", reason: "the string length (10) is longer than the remaining input"},
+	{encoded: "5:hello5:world", reason: "there's trailing data after the first value"},
+	{encoded: "i52ex", reason: "there's trailing data after the integer"},
+	{encoded: "d5:helloi52e3:foo3:bare", reason: "dictionary keys need to appear in sorted order"},
+	{encoded: "d3:fooi1e3:fooi2ee", reason: "dictionary keys can't be repeated"},
+	{encoded: "di1e3:fooe", reason: "dictionary keys need to be strings"},
+	{encoded: "d3:fooe", reason: "the dictionary key has no value"},
+	{encoded: "x", reason: "'x' isn't the start of any bencoded value"},
+}
+
+func testBencodeMalformed(stageHarness *test_case_harness.TestCaseHarness) error {
+	logger := stageHarness.Logger
+	executable := newRecordingExecutable(stageHarness)
+
+	tests := random.RandomElementsFromArray(malformedBencodeTests, 6)
+
+	for _, t := range tests {
+		logger.Infof("Running ./%s decode %s", path.Base(executable.Path), t.encoded)
+		logger.Infof("Expected a non-zero exit code, %s", t.reason)
+		result, err := executable.Run("decode", t.encoded)
+		if err != nil {
+			return err
+		}
+
+		if err = assertNonZeroExitCode(result); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/tester-utils/executable"
+	"github.com/codecrafters-io/tester-utils/logger"
+	"github.com/codecrafters-io/tester-utils/random"
+	"github.com/codecrafters-io/tester-utils/test_case_harness"
+)
+
+type BencodeStressTest struct {
+	description string
+	value       interface{}
+}
+
+func testBencodeStress(stageHarness *test_case_harness.TestCaseHarness) error {
+	logger := stageHarness.Logger
+	// Echoing hundreds of KB of output would flood the logs
+	executable := newQuietExecutable(newRecordingExecutable(stageHarness))
+
+	listDepth := random.RandomInt(300, 600)
+	dictDepth := random.RandomInt(200, 400)
+	stringLength := random.RandomInt(300, 600) * 1024
+
+	nestedList := interface{}(randomBencodeInt())
+	for range listDepth {
+		nestedList = []interface{}{nestedList}
+	}
+
+	nestedDict := interface{}(random.RandomWord())
+	for range dictDepth {
+		nestedDict = map[string]interface{}{random.RandomWord(): nestedDict}
+	}
+
+	tests := []BencodeStressTest{
+		{description: fmt.Sprintf("%d nested lists", listDepth), value: nestedList},
+		{description: fmt.Sprintf("%d nested dictionaries", dictDepth), value: nestedDict},
+		{description: fmt.Sprintf("a %d KB string", stringLength/1024), value: randomLetters(stringLength)},
+	}
+
+	for _, t := range tests {
+		if _, err := runStressDecode(executable, logger, t); err != nil {
+			return err
+		}
+	}
+
+	// A decoder that copies the remaining input for every element takes quadratic time
+	smallListLength := random.RandomInt(5000, 10000)
+	smallList := randomIntList(smallListLength)
+	smallDuration, err := runStressDecode(executable, logger, BencodeStressTest{description: fmt.Sprintf("a list of %d integers", smallListLength), value: smallList})
+	if err != nil {
+		return err
+	}
+
+	largeList := randomIntList(8 * smallListLength)
+	largeDuration, err := runStressDecode(executable, logger, BencodeStressTest{description: fmt.Sprintf("a list of %d integers", 8*smallListLength), value: largeList})
+	if err != nil {
+		return err
+	}
+
+	// Linear decoders take about 8x as long, quadratic ones about 64x. Short runs are dominated by startup time.
+	if largeDuration > 2*time.Second && largeDuration > 24*smallDuration {
+		return fmt.Errorf("Decoding 8x as many elements took %.1fx as long (%s vs %s), your decoder looks like it takes quadratic time. Avoid copying the remaining input for every value you decode", float64(largeDuration)/float64(smallDuration), largeDuration.Round(time.Millisecond), smallDuration.Round(time.Millisecond))
+	}
+
+	return nil
+}
+
+func runStressDecode(executable *recordingExecutable, logger *logger.Logger, t BencodeStressTest) (time.Duration, error) {
+	encoded := encodeBencode(t.value)
+
+	logger.Infof("Running ./%s decode - with %s as stdin (%d bytes)", path.Base(executable.Path), t.description, len(encoded))
+	start := time.Now()
+	result, err := executable.RunWithStdin(encoded, "decode", "-")
+	duration := time.Since(start)
+	if err != nil {
+		return 0, err
+	}
+	logger.Infof("Took %s", duration.Round(time.Millisecond))
+
+	if err = assertExitCode(result, 0); err != nil {
+		logger.Infof("stderr: %s", truncateForLog(result.Stderr))
+		stderr := strings.ToLower(string(result.Stderr))
+		if strings.Contains(stderr, "overflow") || strings.Contains(stderr, "recursion") {
+			logger.Errorf("Your decoder seems to have run out of stack space decoding %s. Use an explicit stack, or make sure your language's recursion limit is high enough", t.description)
+		}
+		return 0, err
+	}
+
+	// The expected and actual values are too large to print in full
+	if err = compareStdoutJSON(result.Stdout, t.value); err != nil {
+		logger.Infof("stdout: %s", truncateForLog(result.Stdout))
+		return 0, fmt.Errorf("Unexpected output for %s. %s", t.description, truncateForLog([]byte(err.Error())))
+	}
+
+	return duration, nil
+}
+
+// newQuietExecutable returns a copy of e that doesn't echo the program's output to the logs
+func newQuietExecutable(e *recordingExecutable) *recordingExecutable {
+	quiet := executable.NewExecutable(e.Path)
+	quiet.TimeoutInMilliseconds = e.TimeoutInMilliseconds
+	quiet.WorkingDir = e.WorkingDir
+	return &recordingExecutable{Executable: quiet, context: e.context}
+}
+
+func truncateForLog(b []byte) string {
+	const maxLength = 500
+	if len(b) <= maxLength {
+		return string(b)
+	}
+	return fmt.Sprintf("%s... (%d more bytes)", b[:maxLength], len(b)-maxLength)
+}
+
+func randomLetters(n int) string {
+	letters := randomBytes(n)
+	for i := range letters {
+		letters[i] = 'a' + letters[i]%26
+	}
+	return string(letters)
+}
+
+func randomIntList(n int) []interface{} {
+	list := make([]interface{}, n)
+	for i := range list {
+		list[i] = random.RandomInt(0, 1000000)
+	}
+	return list
+}
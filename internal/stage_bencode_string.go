@@ -10,7 +10,7 @@ import (
 
 func testBencodeString(stageHarness *test_case_harness.TestCaseHarness) error {
 	logger := stageHarness.Logger
-	executable := stageHarness.Executable
+	executable := newRecordingExecutable(stageHarness)
 
 	randomWord := random.RandomWord()
 	randomWordEncoded := fmt.Sprintf("%d:%s", len(randomWord), randomWord)
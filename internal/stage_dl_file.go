@@ -9,7 +9,7 @@ import (
 
 func testDownloadFile(stageHarness *test_case_harness.TestCaseHarness) error {
 	logger := stageHarness.Logger
-	executable := stageHarness.Executable
+	executable := newRecordingExecutable(stageHarness)
 	executable.TimeoutInMilliseconds = 20000
 
 	t := randomTorrent()
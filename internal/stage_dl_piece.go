@@ -69,7 +69,7 @@ func testDownloadPiece(stageHarness *test_case_harness.TestCaseHarness) error {
 	tests := downloadPieceTests[randomIndex]
 
 	logger := stageHarness.Logger
-	executable := stageHarness.Executable
+	executable := newRecordingExecutable(stageHarness)
 
 	tempDir, err := os.MkdirTemp("", "torrents")
 	if err != nil {
@@ -0,0 +1,150 @@
+package internal
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net"
+	"os"
+	"path"
+
+	"github.com/codecrafters-io/tester-utils/random"
+	"github.com/codecrafters-io/tester-utils/test_case_harness"
+)
+
+func testEncryptedHandshake(stageHarness *test_case_harness.TestCaseHarness) error {
+	logger := stageHarness.Logger
+	executable := newRecordingExecutable(stageHarness)
+
+	tempDir, err := os.MkdirTemp("", "torrents")
+	if err != nil {
+		return err
+	}
+
+	peerPort, err := findFreePort()
+	if err != nil {
+		logger.Errorf("Couldn't find free port: %s", err)
+		return err
+	}
+	peerAddress := fmt.Sprintf("127.0.0.1:%d", peerPort)
+
+	trackerPort, err := findFreePort()
+	if err != nil {
+		logger.Errorf("Couldn't find free port: %s", err)
+		return err
+	}
+
+	trackerAddress := fmt.Sprintf("127.0.0.1:%d", trackerPort)
+	pieceLengthBytes := 32 * 1024
+	content := randomBytes(pieceLengthBytes*random.RandomInt(2, 5) + random.RandomInt(1, pieceLengthBytes))
+	fileLengthBytes := len(content)
+	torrent := TorrentFile{
+		Announce: fmt.Sprintf("http://%s/announce", trackerAddress),
+		Info: TorrentFileInfo{
+			Name:        fmt.Sprintf("%s.bin", random.RandomWord()),
+			Length:      fileLengthBytes,
+			Pieces:      createPiecesStrFromBytes(content, pieceLengthBytes),
+			PieceLength: pieceLengthBytes,
+		},
+	}
+
+	torrentFilePath := path.Join(tempDir, "test.torrent")
+	infoHash, err := torrent.writeToFile(torrentFilePath)
+	if err != nil {
+		logger.Errorf("Error writing torrent file: %s", err)
+		return err
+	}
+
+	expectedPeerID, err := randomHash()
+	if err != nil {
+		return err
+	}
+
+	startTracker(
+		TrackerParams{
+			trackerAddress:   trackerAddress,
+			peersResponse:    createPeersResponse("127.0.0.1", peerPort),
+			expectedInfoHash: infoHash,
+			fileLengthBytes:  fileLengthBytes,
+			logger:           logger,
+			isMagnetLinkTest: false,
+		})
+
+	pieces := splitIntoPieces(content, pieceLengthBytes)
+	// One byte segments catch clients that read the peer's public key and padding with a single read
+	startPeer(
+		PeerConnectionParams{
+			address:  peerAddress,
+			myPeerID: expectedPeerID,
+			infoHash: infoHash,
+			expectedReservedBytes: [][]byte{
+				{0, 0, 0, 0, 0, 0, 0, 0},
+				{0, 0, 0, 0, 0, 16, 0, 0},
+			},
+			bitfield:           fullBitfield(len(pieces)),
+			pieces:             pieces,
+			pieceLengthBytes:   pieceLengthBytes,
+			requiresEncryption: true,
+			faultProfile:       "one-byte-segments",
+			logger:             logger,
+		},
+		handleEncryptedPeer,
+	)
+
+	logger.Infoln("This peer only accepts connections that use Message Stream Encryption (RC4)")
+	logger.Infof("Running ./%s handshake %s %s", path.Base(executable.Path), torrentFilePath, peerAddress)
+	result, err := executable.Run("handshake", torrentFilePath, peerAddress)
+	if err != nil {
+		return err
+	}
+
+	if err = assertExitCode(result, 0); err != nil {
+		return err
+	}
+
+	expected := fmt.Sprintf("Peer ID: %x\n", expectedPeerID)
+
+	if err = assertStdoutContains(result, expected); err != nil {
+		return err
+	}
+
+	logger.Successln("✓ Encrypted handshake completed.")
+
+	pieceIndex := random.RandomInt(0, len(pieces))
+	downloadedFilePath := path.Join(tempDir, fmt.Sprintf("piece-%d", pieceIndex))
+	logger.Infof("Running ./%s download_piece -o %s %s %d", path.Base(executable.Path), downloadedFilePath, torrentFilePath, pieceIndex)
+	result, err = executable.Run("download_piece", "-o", downloadedFilePath, torrentFilePath, fmt.Sprintf("%d", pieceIndex))
+	if err != nil {
+		return err
+	}
+
+	if err = assertExitCode(result, 0); err != nil {
+		return err
+	}
+
+	if err = assertFileSize(downloadedFilePath, int64(len(pieces[pieceIndex]))); err != nil {
+		return err
+	}
+
+	if err = assertFileSHA1(downloadedFilePath, fmt.Sprintf("%x", sha1.Sum(pieces[pieceIndex]))); err != nil {
+		return err
+	}
+
+	logger.Successln("✓ Piece downloaded over an encrypted connection.")
+
+	return nil
+}
+
+// handleEncryptedPeer serves pieces once the MSE handshake is done, which handleRecordedConnection runs before it
+func handleEncryptedPeer(conn net.Conn, params PeerConnectionParams) error {
+	defer conn.Close()
+
+	if err := receiveAndSendHandshake(conn, params); err != nil {
+		return err
+	}
+
+	if err := sendBitfieldMessage(conn, params.bitfield, params.logger); err != nil {
+		return err
+	}
+
+	return servePieces(conn, params.pieces, params.logger, nil)
+}
@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/codecrafters-io/tester-utils/random"
+	"github.com/codecrafters-io/tester-utils/test_case_harness"
+)
+
+func testExtraInfoKeys(stageHarness *test_case_harness.TestCaseHarness) error {
+	logger := stageHarness.Logger
+	executable := newRecordingExecutable(stageHarness)
+
+	tempDir, err := os.MkdirTemp("", "torrents")
+	if err != nil {
+		return err
+	}
+
+	pieceLengthBytes := 16 * 1024
+	content := randomBytes(random.RandomInt(2*pieceLengthBytes, 6*pieceLengthBytes))
+	info := map[string]interface{}{
+		"name":         fmt.Sprintf("%s.bin", random.RandomWord()),
+		"length":       len(content),
+		"piece length": pieceLengthBytes,
+		"pieces":       createPiecesStrFromBytes(content, pieceLengthBytes),
+	}
+
+	extraKeys := map[string]interface{}{
+		"md5sum": fmt.Sprintf("%x", randomBytes(16)),
+		fmt.Sprintf("x-%s", random.RandomWord()): map[string]interface{}{
+			"client":  random.RandomWord(),
+			"build":   random.RandomInt(1, 10000),
+			"options": map[string]interface{}{"tags": []interface{}{random.RandomWord(), random.RandomWord()}},
+		},
+		"collections": []interface{}{random.RandomWord(), random.RandomWord()},
+		"similar":     []interface{}{string(randomBytes(20)), string(randomBytes(20))},
+		"attr":        "x",
+		"sha1":        string(randomBytes(20)),
+	}
+	keys := random.RandomElementsFromArray(sortedKeys(extraKeys), random.RandomInt(3, len(extraKeys)+1))
+	for _, key := range keys {
+		info[key] = extraKeys[key]
+	}
+	logger.Infof("Generated a torrent with extra keys in the info dictionary: %v", sortedKeys(info))
+
+	torrentPath := path.Join(tempDir, "extra.torrent")
+	torrent := map[string]interface{}{
+		"announce": "http://bittorrent-test-tracker.codecrafters.io/announce",
+		"info":     info,
+	}
+	if err := os.WriteFile(torrentPath, encodeBencode(torrent), 0644); err != nil {
+		logger.Errorf("Error writing torrent file: %s", err)
+		return err
+	}
+	infoHash := sha1.Sum(encodeBencode(info))
+
+	logger.Infof("Running ./%s info %s", path.Base(executable.Path), torrentPath)
+	result, err := executable.Run("info", torrentPath)
+	if err != nil {
+		return err
+	}
+
+	if err = assertExitCode(result, 0); err != nil {
+		return err
+	}
+
+	if err = assertStdoutContains(result, fmt.Sprintf("Length: %d", len(content))); err != nil {
+		return err
+	}
+
+	if err = assertStdoutContains(result, fmt.Sprintf("Info Hash: %x", infoHash)); err != nil {
+		logInfoHashMistake(logger, torrentPath, result.Stdout)
+		return err
+	}
+
+	logger.Successln("✓ Info Hash includes every key of the info dictionary.")
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"net"
+	"os"
+	"path"
+
+	logger "github.com/codecrafters-io/tester-utils/logger"
+	"github.com/codecrafters-io/tester-utils/random"
+	"github.com/codecrafters-io/tester-utils/test_case_harness"
+)
+
+func testFragmentedMessages(stageHarness *test_case_harness.TestCaseHarness) error {
+	logger := stageHarness.Logger
+	executable := newRecordingExecutable(stageHarness)
+
+	tempDir, err := os.MkdirTemp("", "torrents")
+	if err != nil {
+		logger.Errorln("Couldn't create temp directory")
+		return err
+	}
+
+	peerPort, err := findFreePort()
+	if err != nil {
+		logger.Errorf("Couldn't find free port: %s", err)
+		return err
+	}
+
+	trackerPort, err := findFreePort()
+	if err != nil {
+		logger.Errorf("Couldn't find free port: %s", err)
+		return err
+	}
+	trackerAddress := fmt.Sprintf("127.0.0.1:%d", trackerPort)
+
+	pieceLengthBytes := 32 * 1024
+	content := randomBytes(pieceLengthBytes*random.RandomInt(2, 5) + random.RandomInt(1, pieceLengthBytes))
+	torrent := TorrentFile{
+		Announce: fmt.Sprintf("http://%s/announce", trackerAddress),
+		Info: TorrentFileInfo{
+			Name:        fmt.Sprintf("%s.bin", random.RandomWord()),
+			Length:      len(content),
+			Pieces:      createPiecesStrFromBytes(content, pieceLengthBytes),
+			PieceLength: pieceLengthBytes,
+		},
+	}
+
+	torrentFilePath := path.Join(tempDir, "fragmented.torrent")
+	infoHash, err := torrent.writeToFile(torrentFilePath)
+	if err != nil {
+		logger.Errorf("Error writing torrent file: %s", err)
+		return err
+	}
+
+	peerID, err := randomHash()
+	if err != nil {
+		return err
+	}
+
+	startTracker(TrackerParams{
+		trackerAddress:   trackerAddress,
+		peersResponse:    createPeersResponse("127.0.0.1", peerPort),
+		expectedInfoHash: infoHash,
+		fileLengthBytes:  len(content),
+		logger:           logger,
+	})
+
+	pieces := splitIntoPieces(content, pieceLengthBytes)
+	startPeer(
+		PeerConnectionParams{
+			address:  fmt.Sprintf("127.0.0.1:%d", peerPort),
+			myPeerID: peerID,
+			infoHash: infoHash,
+			expectedReservedBytes: [][]byte{
+				{0, 0, 0, 0, 0, 0, 0, 0},
+				{0, 0, 0, 0, 0, 16, 0, 0},
+			},
+			bitfield:         fullBitfield(len(pieces)),
+			pieces:           pieces,
+			pieceLengthBytes: pieceLengthBytes,
+			faultProfile:     "random-segments-after-handshake",
+			logger:           logger,
+		},
+		handleFragmentedMessages,
+	)
+
+	downloadedFilePath := path.Join(tempDir, torrent.Info.Name)
+	logger.Infoln("The peer sends the handshake and bitfield in one segment, and splits piece messages into small segments")
+	logger.Infof("Running ./%s download -o %s %s", path.Base(executable.Path), downloadedFilePath, torrentFilePath)
+	result, err := executable.Run("download", "-o", downloadedFilePath, torrentFilePath)
+	if err != nil {
+		return err
+	}
+
+	if err = assertExitCode(result, 0); err != nil {
+		logFramingHint(logger)
+		return err
+	}
+
+	if err = assertFileSize(downloadedFilePath, int64(len(content))); err != nil {
+		logFramingHint(logger)
+		return err
+	}
+
+	if err = assertFileSHA1(downloadedFilePath, fmt.Sprintf("%x", sha1.Sum(content))); err != nil {
+		logFramingHint(logger)
+		return err
+	}
+
+	logger.Successln("✓ File downloaded from fragmented and coalesced messages.")
+
+	return nil
+}
+
+func logFramingHint(logger *logger.Logger) {
+	logger.Infoln("A single read from a TCP connection can return part of a message, or the end of one message and the start of the next. " +
+		"Read the 68 byte handshake first, then read every message by its 4 byte length prefix: keep reading until the whole message has arrived, " +
+		"and keep any extra bytes for the next message.")
+}
+
+// coalescingConn buffers writes until flush sends them with a single write
+type coalescingConn struct {
+	net.Conn
+	pending bytes.Buffer
+}
+
+func (c *coalescingConn) Write(b []byte) (int, error) {
+	return c.pending.Write(b)
+}
+
+func (c *coalescingConn) protocolViolation() error {
+	return protocolViolationOf(c.Conn)
+}
+
+func (c *coalescingConn) flush() error {
+	_, err := c.Conn.Write(c.pending.Bytes())
+	c.pending.Reset()
+	return err
+}
+
+// handleFragmentedMessages sends the handshake and bitfield with one write. The peer's fault profile keeps that write
+// whole and splits the piece messages after it into random segments.
+func handleFragmentedMessages(conn net.Conn, params PeerConnectionParams) error {
+	defer conn.Close()
+
+	logger := params.logger
+	coalesced := &coalescingConn{Conn: conn}
+	if err := receiveAndSendHandshake(coalesced, params); err != nil {
+		return err
+	}
+	if err := sendBitfieldMessage(coalesced, params.bitfield, logger); err != nil {
+		return err
+	}
+	logger.Debugf("Sending handshake and bitfield in one segment of %d bytes", coalesced.pending.Len())
+	if err := coalesced.flush(); err != nil {
+		return err
+	}
+
+	return servePieces(conn, params.pieces, logger, nil)
+}
@@ -11,7 +11,7 @@ import (
 
 func testHandshake(stageHarness *test_case_harness.TestCaseHarness) error {
 	logger := stageHarness.Logger
-	executable := stageHarness.Executable
+	executable := newRecordingExecutable(stageHarness)
 
 	tempDir, err := os.MkdirTemp("", "torrents")
 	if err != nil {
@@ -65,7 +65,7 @@ func testHandshake(stageHarness *test_case_harness.TestCaseHarness) error {
 
 	peersResponse := createPeersResponse("127.0.0.1", peerPort)
 
-	go listenAndServeTrackerResponse(
+	startTracker(
 		TrackerParams{
 			trackerAddress:   trackerAddress,
 			peersResponse:    peersResponse,
@@ -75,7 +75,7 @@ func testHandshake(stageHarness *test_case_harness.TestCaseHarness) error {
 			isMagnetLinkTest: false,
 		})
 
-	go waitAndHandlePeerConnection(
+	startPeer(
 		PeerConnectionParams{
 			address:               peerAddress,
 			myPeerID:              expectedPeerID,
@@ -105,11 +105,8 @@ func testHandshake(stageHarness *test_case_harness.TestCaseHarness) error {
 	return nil
 }
 
-func handleHandshake(conn net.Conn, params PeerConnectionParams) {
+func handleHandshake(conn net.Conn, params PeerConnectionParams) error {
 	defer conn.Close()
 
-	err := receiveAndSendHandshake(conn, params)
-	if err != nil {
-		return
-	}
+	return receiveAndSendHandshake(conn, params)
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/sha1"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -19,7 +20,8 @@ import (
 	"github.com/jackpal/bencode-go"
 )
 
-type ConnectionHandler func(net.Conn, PeerConnectionParams)
+// ConnectionHandler talks to the user's client over conn, and returns an error if the exchange failed
+type ConnectionHandler func(net.Conn, PeerConnectionParams) error
 
 type PeerConnectionParams struct {
 	address               string
@@ -30,9 +32,25 @@ type PeerConnectionParams struct {
 	metadataSizeBytes     int
 	bitfield              []byte
 	magnetLink            MagnetTestTorrentInfo
+	pieces                [][]byte
+	pieceLengthBytes      int
+	merkleTrees           map[[32]byte]*merkleTree
+	isV2Torrent           bool
+	isPrivateTorrent      bool
+	myReservedBytes       []byte
+	faultProfile          string
+	requiresEncryption    bool
 	logger                *logger.Logger
 }
 
+type WebSeedParams struct {
+	address      string
+	filename     string
+	content      []byte
+	servedRanges chan string
+	logger       *logger.Logger
+}
+
 type TrackerParams struct {
 	trackerAddress        string
 	peersResponse         []byte
@@ -41,6 +59,9 @@ type TrackerParams struct {
 	logger                *logger.Logger
 	myMetadataExtensionID uint8
 	isMagnetLinkTest      bool
+	faultProfile          string
+	// torrentVersion picks the hint logged when the client sends the wrong info hash
+	torrentVersion torrentVersion
 }
 
 var samplePieceHashes = []string{
@@ -61,7 +82,6 @@ type TestTorrentInfo struct {
 	infohash       string
 	length         int64
 	expectedSha1   string
-	incorrectSha1  []string
 }
 
 var testTorrents = []TestTorrentInfo{
@@ -72,31 +92,6 @@ var testTorrents = []TestTorrentInfo{
 		infohash:       "c77829d2a77d6516f88cd7a3de1a26abcbfab0db",
 		length:         2994120,
 		expectedSha1:   "89d5dcbb92d31f040f8fe42b559fd6ec7f4d83a5",
-		incorrectSha1: []string{
-			"9fe338a7333eaf95533895e0340df633f0985462",
-			"38dd803e5272c58ef1edeb808d160e9b02c208bd",
-			"4ae8b721254a1e9363ad8f2458d184075b18ff50",
-			"c90f0c6574de821be40cf741357dafb99429ca70",
-			"1a390773ed42171e63e083ed1fffb860604fbba4",
-			"d53e7626e60c1acc1d3f1269955e99e3122c19ee",
-			"d2087fec76212da342e864351950c92a363dfedc",
-			"d4c98d43da1038fe79d2351232e68910b123e603",
-			"cb46db2fadb568903716c5d1f007546347da68e4",
-			"b4be41970720fd343cfe2aefe427f4711486d44c",
-			"a89ff934345bba1c6fff9fdec529407a8487ea6e",
-			"d35e869ac9e7a49cb63c50e6f95cf174e2288fe6",
-			"f46ae8bb99685dfb1ceaa73eb8699f214bc3917a",
-			"54d46efe1684a3704eb6371d8b303c1734d55aca",
-			"6b54d951b7eb715002aef478846741735f4ed6ca",
-			"ef8e41743f4c52aedc99852dcf6ee06adc80fba9",
-			"b41b700691c17202f3f1c8bbad88db8a594f9406",
-			"3c8fa675dd60c4b714b8092d08eca65fbf1c8224",
-			"c9620ee5d267ef0d12ac629fff6d2a08cae16e04",
-			"bacb060807196ddf9a608c02a9b51d06f1f5630b",
-			"b9f6595abc73c2a9d7e4cd8d58b9a6b0daf3a2c2",
-			"ddff12f2cd2b8d7b17eb5ddddf723203f57a4583",
-			"751c1cce843365f2c0243353dd37c663fc136a38",
-		},
 	},
 	{
 		filename:       "congratulations.gif.torrent",
@@ -105,31 +100,6 @@ var testTorrents = []TestTorrentInfo{
 		infohash:       "1cad4a486798d952614c394eb15e75bec587fd08",
 		length:         820892,
 		expectedSha1:   "fe3cc9002bc84c4776c3a962a717244c9cb962c0",
-		incorrectSha1: []string{
-			"a51f27f7098ad52b23d43b7377a038061dc86d82",
-			"17f995b0a08a9546fa21248a7b3dd7b88900550b",
-			"0475ae5ced66e355114367ca3531513bc741d584",
-			"f3b7a5af63de420c195bff73ef9a1eb6adbe316c",
-			"25c979353bd0a94561234dc98c21e79fb2d3f36a",
-			"4dc9c94a9814dffef84eccb30d1f402a93c967b4",
-			"ed63aa89af68e20c7daa8958fb2e6549ad9d46b9",
-			"f7dbfd0ea39c8867ec41a43cdae8f94ecf041c5c",
-			"c50cbe4589fd7cbb13f3702ec95415e5672cc16b",
-			"8ce1b44f4cb957c82a09e1ea9816240cf75858cb",
-			"448875e4899066578afc755a5896a255b83b8765",
-			"f83036e6fe380a97a93e47d916cf344ad1137f01",
-			"e7df0e8ee5f434bbafa3ea1899c833f07b68e34f",
-			"06ecfa7284cd8e6d23cb1c79bf74e039c1ca46d0",
-			"151da9dc8374787dd823a2e0de40ee48027c5619",
-			"31b6f8167791cdab14c742b24bcfd625f088afaa",
-			"364fda25722abb30ef7260f8d9f1dcedd6fe442a",
-			"9ffc716aa95846cf8b4f550b83b8717dad473f2c",
-			"77288778c94881d13307446fdc4671853c36d3e4",
-			"6af4d48cf003ad80849cffe25745417651d57944",
-			"88c0eedcf9bdbaf9093e138703b9c217f0176d07",
-			"1fa4e4e1cf5842f20ec3a5c8799b65fef08338df",
-			"adceada73d45d1e32ca861c83d2ba6f247643c15",
-		},
 	},
 	{
 		filename:       "itsworking.gif.torrent",
@@ -138,31 +108,6 @@ var testTorrents = []TestTorrentInfo{
 		infohash:       "70edcac2611a8829ebf467a6849f5d8408d9d8f4",
 		length:         2549700,
 		expectedSha1:   "683e899db9d7a38e50eb87874b62f7fdd0c14c9c",
-		incorrectSha1: []string{
-			"031ca6e6c27062f87935fad1cb4ceec94f4b7342",
-			"ef1fb68a9ce29987375d4a9d27f70d3f39466292",
-			"f580128989c01fba318fa6079712d2a6e5629d79",
-			"18d3c1d13cbda0b453c7d80d3b0af0b5b693109c",
-			"8de41c8ec28703aaa57a5de4fa0cdb3a63809a9e",
-			"df7ee75594cbf87f5c01f5b8e806fc378c71c881",
-			"31fc5c2997edef37e5b6c92faba6324aa930315c",
-			"d2aa57b57d425815be6021ac30d4a6fb30bd2914",
-			"c0096df565c3c5d8f7ded6b1ddad01531b1507bd",
-			"294673ce148426c79afede0a77497f9df0e797ab",
-			"c6566181dd51e709a1125c329008f144e00e011e",
-			"f7aafeb3f9e71bcdb886c80d2e79e09989f31eac",
-			"79fee8553cb38e3c8f012b8c5a34ac8a1ceba2a6",
-			"a17b52dd5dafbeb6407b115a7ad8f72247ed0ddb",
-			"cb832682ddc645b95cf5926cce3d30cd54375584",
-			"46118c1764e8c162f7cf11fa219412b4739fd3db",
-			"9e8634490c61f49d1a1027de12f8f87e4be9a577",
-			"05ef950cdbed43ea342e892e2ea392ad1df985fd",
-			"b9750268e247dda0ae9e470ff009449e239910d1",
-			"900b74c76c3a9ab3fe3e7baf81bad6b184ac348f",
-			"89fad97dbde4097e4498c32d31da435b5c7ab95b",
-			"c36b30d886a1c164ecf9c3a31019f8983525ed6f",
-			"562de033449e4e5f09c755c7a54e0ff1ee4f0f75",
-		},
 	},
 }
 
@@ -244,12 +189,13 @@ func calculateSHA1(filePath string) (string, error) {
 	return hex.EncodeToString(hashBytes), nil
 }
 
-func listenAndServeTrackerResponse(p TrackerParams) {
+// startTracker listens on the tracker address and serves announce requests until the test case ends
+func startTracker(p TrackerParams) {
 	logger := p.logger
 	mux := http.NewServeMux()
-	mux.HandleFunc("/announce", func(w http.ResponseWriter, r *http.Request) {
-		serveTrackerResponse(w, r, p.peersResponse, p.expectedInfoHash, p.fileLengthBytes, p.isMagnetLinkTest, p.logger)
-	})
+	mux.HandleFunc("/announce", recordTrackerResponses(logger, func(w http.ResponseWriter, r *http.Request) {
+		serveTrackerResponse(w, r, p.peersResponse, p.expectedInfoHash, p.torrentVersion, p.fileLengthBytes, p.isMagnetLinkTest, p.logger)
+	}))
 
 	// Redirect /announce/ to /announce while preserving query parameters
 	mux.HandleFunc("/announce/", func(w http.ResponseWriter, r *http.Request) {
@@ -264,13 +210,10 @@ func listenAndServeTrackerResponse(p TrackerParams) {
 	})
 
 	logger.Debugf("Tracker started on address %s...\n", p.trackerAddress)
-	err := http.ListenAndServe(p.trackerAddress, mux)
-	if err != nil {
-		logger.Errorf("Error: %s", err)
-	}
+	serveHTTP(p.trackerAddress, mux, p.faultProfile, logger)
 }
 
-func serveTrackerResponse(w http.ResponseWriter, r *http.Request, responseContent []byte, expectedInfoHash [20]byte, fileLengthBytes int, isMagnetLinkTest bool, logger *logger.Logger) {
+func serveTrackerResponse(w http.ResponseWriter, r *http.Request, responseContent []byte, expectedInfoHash [20]byte, version torrentVersion, fileLengthBytes int, isMagnetLinkTest bool, logger *logger.Logger) {
 	if r.Method != "GET" {
 		logger.Errorln("HTTP method GET expected")
 		http.Error(w, "HTTP method GET expected", http.StatusMethodNotAllowed)
@@ -382,7 +325,7 @@ func serveTrackerResponse(w http.ResponseWriter, r *http.Request, responseConten
 	receivedHash := []byte(infoHash)
 
 	if !bytes.Equal(receivedHash[:], expectedInfoHash[:]) {
-		logger.Errorln("info_hash correct length, but does not match expected value. It needs to be SHA-1 of the bencoded info dictionary from the torrent file")
+		logger.Errorf("info_hash correct length, but does not match expected value. It needs to be %s", version.infoHashDescription())
 		w.Write([]byte("d14:failure reason25:provided invalid infohashe"))
 		return
 	}
@@ -390,6 +333,109 @@ func serveTrackerResponse(w http.ResponseWriter, r *http.Request, responseConten
 	w.Write(responseContent)
 }
 
+// startWebSeed listens on the web seed address and serves the file until the test case ends
+func startWebSeed(p WebSeedParams) {
+	logger := p.logger
+	mux := http.NewServeMux()
+	mux.HandleFunc("/files/"+p.filename, func(w http.ResponseWriter, r *http.Request) {
+		serveWebSeedRange(w, r, p)
+	})
+
+	logger.Debugf("Web seed started on address %s...\n", p.address)
+	serveHTTP(p.address, mux, "", logger)
+}
+
+// serveHTTP listens on the address and serves handler until the test case ends. Connections are recorded in the
+// test case's packet capture, and run under the given fault profile.
+func serveHTTP(address string, handler http.Handler, faultProfile string, logger *logger.Logger) {
+	tcpListener, err := net.Listen("tcp", address)
+	if err != nil {
+		logger.Errorf("Error: %s", err)
+		return
+	}
+
+	servers := serverLifecycleFor(logger)
+	release := servers.track(tcpListener, "HTTP server on "+address)
+
+	listener, err := withFaults(captureListener(tcpListener, logger), faultProfile, logger)
+	if err != nil {
+		release()
+		logger.Errorf("Error: %s", err)
+		return
+	}
+
+	servers.serveHTTP(meterListener(listener, logger), meterRequests(handler, logger), release, logger)
+}
+
+func serveWebSeedRange(w http.ResponseWriter, r *http.Request, p WebSeedParams) {
+	logger := p.logger
+	if r.Method != "GET" {
+		logger.Errorln("HTTP method GET expected for web seed requests")
+		http.Error(w, "HTTP method GET expected", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader == "" {
+		logger.Errorln("Web seed request is missing the Range header. Request only the bytes of the piece you need, for example: Range: bytes=0-16383")
+		http.Error(w, "Range header required", http.StatusBadRequest)
+		return
+	}
+
+	start, end, err := parseByteRange(rangeHeader, len(p.content))
+	if err != nil {
+		logger.Errorf("Invalid Range header %q: %s", rangeHeader, err)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", len(p.content)))
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	servedRange := fmt.Sprintf("bytes=%d-%d", start, end)
+	logger.Infof("Web seed serving %s (%d bytes)", servedRange, end-start+1)
+	select {
+	case p.servedRanges <- servedRange:
+	default:
+	}
+
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(p.content)))
+	w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(p.content[start : end+1])
+}
+
+// parseByteRange parses a single range of the form bytes=<start>-<end> or bytes=<start>-
+func parseByteRange(header string, contentLength int) (int, int, error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found {
+		return 0, 0, errors.New("expected range to start with bytes=")
+	}
+	if strings.Contains(spec, ",") {
+		return 0, 0, errors.New("multiple ranges in one request are not supported, request one range at a time")
+	}
+
+	startStr, endStr, found := strings.Cut(spec, "-")
+	if !found {
+		return 0, 0, errors.New("expected range of the form bytes=<start>-<end>")
+	}
+	start, err := strconv.Atoi(startStr)
+	if err != nil || start < 0 {
+		return 0, 0, fmt.Errorf("invalid range start: %q", startStr)
+	}
+	end := contentLength - 1
+	if endStr != "" {
+		end, err = strconv.Atoi(endStr)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid range end: %q", endStr)
+		}
+	}
+	if start > end || start >= contentLength {
+		return 0, 0, fmt.Errorf("range is outside of the file, file length is %d bytes", contentLength)
+	}
+
+	return start, min(end, contentLength-1), nil
+}
+
 func createPeersResponse(peerIP string, peerPort int) []byte {
 	peerBytes := make([]byte, 6)
 	peerIPAddress := net.ParseIP(peerIP).To4()
@@ -398,6 +444,14 @@ func createPeersResponse(peerIP string, peerPort int) []byte {
 	peerBytes[4] = byte(peerPort >> 8)
 	peerBytes[5] = byte(peerPort)
 
+	return createCompactPeersResponse(peerBytes)
+}
+
+func createEmptyPeersResponse() []byte {
+	return createCompactPeersResponse([]byte{})
+}
+
+func createCompactPeersResponse(peerBytes []byte) []byte {
 	response := map[string]interface{}{
 		"complete":     1,
 		"incomplete":   0,
@@ -414,14 +468,21 @@ func createPeersResponse(peerIP string, peerPort int) []byte {
 	return buf.Bytes()
 }
 
-func receiveAndSendHandshake(conn net.Conn, peer PeerConnectionParams) (err error) {
+func receiveAndSendHandshake(conn net.Conn, peer PeerConnectionParams) error {
+	_, err := exchangeHandshake(conn, peer)
+	return err
+}
+
+// exchangeHandshake is receiveAndSendHandshake for handlers that need the client's reserved bytes
+func exchangeHandshake(conn net.Conn, peer PeerConnectionParams) (clientReservedBytes [8]byte, err error) {
 	defer logOnExit(peer.logger, &err)
 
 	logger := peer.logger
 	handshake, err := readHandshake(conn, logger)
 	if err != nil {
-		return fmt.Errorf("error reading handshake: %s", err)
+		return clientReservedBytes, fmt.Errorf("error reading handshake: %s", err)
 	}
+	clientReservedBytes = handshake.Reserved
 
 	if !isEqualToOneOf(handshake.Reserved[:], peer.expectedReservedBytes...) {
 		var formattedStrings []string
@@ -429,42 +490,73 @@ func receiveAndSendHandshake(conn net.Conn, peer PeerConnectionParams) (err erro
 			formattedString := fmt.Sprintf("%v", byteSlice)
 			formattedStrings = append(formattedStrings, formattedString)
 		}
-		return fmt.Errorf("did you send reserved bytes? expected bytes: %s but received: %v", strings.Join(formattedStrings, " or "), handshake.Reserved)
+		if peer.isPrivateTorrent && handshake.Reserved[7]&0x01 != 0 {
+			return clientReservedBytes, fmt.Errorf("received reserved bytes %v with the DHT bit set. Clients must not advertise DHT support for private torrents", handshake.Reserved)
+		}
+		return clientReservedBytes, fmt.Errorf("did you send reserved bytes? expected bytes: %s but received: %v", strings.Join(formattedStrings, " or "), handshake.Reserved)
 	}
 
 	if !bytes.Equal(handshake.InfoHash[:], peer.infoHash[:]) {
-		return fmt.Errorf("expected infohash %x but got %x", peer.infoHash, handshake.InfoHash)
+		if peer.isV2Torrent {
+			return clientReservedBytes, fmt.Errorf("expected infohash %x but got %x. For v2 torrents, the handshake uses the SHA-256 info hash truncated to 20 bytes", peer.infoHash, handshake.InfoHash)
+		}
+		return clientReservedBytes, fmt.Errorf("expected infohash %x but got %x", peer.infoHash, handshake.InfoHash)
 	}
 
 	logger.Debugf("Received handshake: [infohash: %x, peer_id: %x]\n", handshake.InfoHash, handshake.PeerID)
 	logger.Debugf("Sending back handshake with peer_id: %x", peer.myPeerID)
 
 	var reservedBytes [8]byte
-	copy(reservedBytes[:], peer.expectedReservedBytes[0])
+	if peer.myReservedBytes != nil {
+		copy(reservedBytes[:], peer.myReservedBytes)
+	} else {
+		copy(reservedBytes[:], peer.expectedReservedBytes[0])
+	}
 
 	err = sendHandshake(conn, reservedBytes, handshake.InfoHash, peer.myPeerID)
 	if err != nil {
-		return err
+		return clientReservedBytes, err
 	}
-	return nil
+	return clientReservedBytes, nil
 }
 
-func waitAndHandlePeerConnection(p PeerConnectionParams, handler ConnectionHandler) {
+// startPeer listens on the peer address and handles connections until the test case ends
+func startPeer(p PeerConnectionParams, handler ConnectionHandler) {
 	logger := p.logger
 	logger.Debugf("Peer listening on address: %s", p.address)
-	listener, err := net.Listen("tcp", p.address)
+	tcpListener, err := net.Listen("tcp", p.address)
 	if err != nil {
 		logger.Errorf("Error: %s", err)
 		return
 	}
-	defer listener.Close()
 
+	servers := serverLifecycleFor(logger)
+	release := servers.track(tcpListener, "peer on "+p.address)
+
+	listener, err := withFaults(captureListener(tcpListener, logger), p.faultProfile, logger)
+	if err != nil {
+		release()
+		logger.Errorf("Error: %s", err)
+		return
+	}
+
+	servers.goServe(func() {
+		defer release()
+		acceptPeerConnections(meterListener(listener, logger), p, handler)
+	})
+}
+
+// acceptPeerConnections handles connections one at a time until the listener is closed
+func acceptPeerConnections(listener net.Listener, p PeerConnectionParams, handler ConnectionHandler) {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			logger.Errorf("Error accepting connection: %s", err)
+			if !errors.Is(err, net.ErrClosed) {
+				p.logger.Errorf("Error accepting connection: %s", err)
+			}
+			return
 		}
-		handler(conn, p)
+		handleRecordedConnection(conn, p, handler)
 	}
 }
 
@@ -476,6 +568,14 @@ func randomHash() ([20]byte, error) {
 	return hash, nil
 }
 
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(random.RandomInt(0, 256))
+	}
+	return b
+}
+
 func isEqualToOneOf(target []byte, arrays ...[]byte) bool {
 	for _, array := range arrays {
 		if bytes.Equal(target, array) {
@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/codecrafters-io/tester-utils/random"
+	"github.com/codecrafters-io/tester-utils/test_case_harness"
+)
+
+type InfoOutputField struct {
+	name     string
+	expected string
+}
+
+func testInfoFields(stageHarness *test_case_harness.TestCaseHarness) error {
+	logger := stageHarness.Logger
+	executable := newRecordingExecutable(stageHarness)
+
+	tempDir, err := os.MkdirTemp("", "torrents")
+	if err != nil {
+		return err
+	}
+
+	pieceLengthBytes := random.RandomElementFromArray([]int{16 * 1024, 32 * 1024, 64 * 1024})
+	content := randomBytes(random.RandomInt(2*pieceLengthBytes, 8*pieceLengthBytes))
+	torrent := TorrentFile{
+		Announce: fmt.Sprintf("http://%s.example.com:%d/announce", random.RandomWord(), random.RandomInt(1024, 65536)),
+		Info: TorrentFileInfo{
+			Name:        fmt.Sprintf("%s.bin", random.RandomWord()),
+			Length:      len(content),
+			Pieces:      createPiecesStrFromBytes(content, pieceLengthBytes),
+			PieceLength: pieceLengthBytes,
+		},
+	}
+
+	// Optional fields are only printed when they're present in the torrent
+	if random.RandomInt(0, 2) == 0 {
+		torrent.Comment = strings.Join(random.RandomWords(4), " ")
+	}
+	if random.RandomInt(0, 2) == 0 {
+		torrent.CreatedBy = fmt.Sprintf("%s %d.%d", random.RandomWord(), random.RandomInt(1, 5), random.RandomInt(0, 10))
+	}
+	if random.RandomInt(0, 2) == 0 {
+		torrent.CreationDate = random.RandomInt(1000000000, 1800000000)
+	}
+
+	torrentPath := path.Join(tempDir, "test.torrent")
+	infoHash, err := torrent.writeToFile(torrentPath)
+	if err != nil {
+		logger.Errorf("Error writing torrent file: %s", err)
+		return err
+	}
+
+	fields := []InfoOutputField{
+		{name: "Tracker URL", expected: torrent.Announce},
+		{name: "Length", expected: fmt.Sprintf("%d", torrent.Info.Length)},
+		{name: "Info Hash", expected: fmt.Sprintf("%x", infoHash)},
+		{name: "Piece Length", expected: fmt.Sprintf("%d", torrent.Info.PieceLength)},
+	}
+	if torrent.Comment != "" {
+		fields = append(fields, InfoOutputField{name: "Comment", expected: torrent.Comment})
+	}
+	if torrent.CreatedBy != "" {
+		fields = append(fields, InfoOutputField{name: "Created By", expected: torrent.CreatedBy})
+	}
+	if torrent.CreationDate != 0 {
+		fields = append(fields, InfoOutputField{name: "Creation Date", expected: fmt.Sprintf("%d", torrent.CreationDate)})
+	}
+
+	logger.Infof("Running ./%s info %s", path.Base(executable.Path), torrentPath)
+	result, err := executable.Run("info", torrentPath)
+	if err != nil {
+		return err
+	}
+
+	if err = assertExitCode(result, 0); err != nil {
+		return err
+	}
+
+	lines := strings.Split(string(result.Stdout), "\n")
+	failures := 0
+	for _, field := range fields {
+		expectedLine := fmt.Sprintf("%s: %s", field.name, field.expected)
+		actualLine := findLineWithPrefix(lines, field.name+":")
+		switch {
+		case actualLine == expectedLine:
+			logger.Successf("✓ %s is correct", field.name)
+		case actualLine == "":
+			logger.Errorf("✗ %s is missing, expected %q", field.name, expectedLine)
+			failures++
+		default:
+			logger.Errorf("✗ %s is wrong, expected %q, got %q", field.name, expectedLine, actualLine)
+			if field.name == "Info Hash" {
+				logInfoHashMistake(logger, torrentPath, []byte(actualLine))
+			}
+			failures++
+		}
+	}
+
+	pieceHashes := splitPieceHashes(torrent.Info.Pieces)
+	for i, pieceHash := range pieceHashes {
+		if !containsLine(lines, pieceHash) {
+			logger.Errorf("✗ Piece hash %d is missing, expected a line with %s", i, pieceHash)
+			failures++
+		}
+	}
+	if findLineWithPrefix(lines, "Piece Hashes:") == "" {
+		logger.Errorln("✗ \"Piece Hashes:\" header is missing")
+		failures++
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d fields are missing or wrong", failures)
+	}
+
+	logger.Successf("✓ All %d piece hashes are correct", len(pieceHashes))
+
+	return nil
+}
+
+func findLineWithPrefix(lines []string, prefix string) string {
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), prefix) {
+			return strings.TrimSpace(line)
+		}
+	}
+	return ""
+}
+
+func containsLine(lines []string, expected string) bool {
+	for _, line := range lines {
+		if strings.TrimSpace(line) == expected {
+			return true
+		}
+	}
+	return false
+}
+
+func splitPieceHashes(pieces string) []string {
+	var hashes []string
+	for i := 0; i+20 <= len(pieces); i += 20 {
+		hashes = append(hashes, fmt.Sprintf("%x", pieces[i:i+20]))
+	}
+	return hashes
+}
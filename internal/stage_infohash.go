@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"os"
 	"path"
-	"strings"
 
 	"github.com/codecrafters-io/tester-utils/random"
 	"github.com/codecrafters-io/tester-utils/test_case_harness"
@@ -12,7 +11,7 @@ import (
 
 func testInfoHash(stageHarness *test_case_harness.TestCaseHarness) error {
 	logger := stageHarness.Logger
-	executable := stageHarness.Executable
+	executable := newRecordingExecutable(stageHarness)
 
 	tempDir, err := os.MkdirTemp("", "torrents")
 	if err != nil {
@@ -44,13 +43,7 @@ func testInfoHash(stageHarness *test_case_harness.TestCaseHarness) error {
 		expected := fmt.Sprintf("Info Hash: %s", torrent.infohash)
 
 		if err = assertStdoutContains(result, expected); err != nil {
-			output := string(result.Stdout)
-			for _, incorrectHash := range torrent.incorrectSha1 {
-				if strings.Contains(output, incorrectHash) {
-					logger.Errorln("WARNING: In your bencoded info dictionary, ensure that keys appear in sorted order.")
-					break
-				}
-			}
+			logInfoHashMistake(logger, torrentPath, result.Stdout)
 			return err
 		}
 	}
@@ -10,7 +10,7 @@ import (
 func testMagnetDownloadFile(stageHarness *test_case_harness.TestCaseHarness) error {
 
 	logger := stageHarness.Logger
-	executable := stageHarness.Executable
+	executable := newRecordingExecutable(stageHarness)
 
 	t := randomMagnetLink()
 
@@ -67,7 +67,7 @@ func testMagnetDownloadPiece(stageHarness *test_case_harness.TestCaseHarness) er
 	tests := magnetLinkPieceTests[randomIndex]
 
 	logger := stageHarness.Logger
-	executable := stageHarness.Executable
+	executable := newRecordingExecutable(stageHarness)
 
 	tempDir, err := os.MkdirTemp("", "torrents")
 	if err != nil {
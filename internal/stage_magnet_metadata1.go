@@ -12,19 +12,18 @@ import (
 	"github.com/jackpal/bencode-go"
 )
 
-var metadataRequestChannel = make(chan bool)
-
 func testMagnetRequestMetadata(stageHarness *test_case_harness.TestCaseHarness) error {
 	logger := stageHarness.Logger
-	executable := stageHarness.Executable
+	executable := newRecordingExecutable(stageHarness)
 
 	magnetLink := randomMagnetLink()
 	params, err := NewMagnetTestParams(magnetLink, logger)
 	if err != nil {
 		return err
 	}
-	go listenAndServeTrackerResponse(params.toTrackerParams())
-	go waitAndHandlePeerConnection(params.toPeerConnectionParams(), handleMetadataRequest)
+	metadataRequests := make(chan bool, 1)
+	startTracker(params.toTrackerParams())
+	startPeer(params.toPeerConnectionParams(), handleMetadataRequest(metadataRequests))
 
 	logger.Infof("Running ./your_bittorrent.sh magnet_info %q", params.MagnetUrlEncoded)
 	result, err := executable.Run("magnet_info", params.MagnetUrlEncoded)
@@ -36,43 +35,46 @@ func testMagnetRequestMetadata(stageHarness *test_case_harness.TestCaseHarness)
 		return err
 	}
 
-	success := <-metadataRequestChannel
-	if success {
+	if success := <-metadataRequests; success {
 		return nil
 	}
 
 	return errors.New("metadata request not received")
 }
 
-func handleMetadataRequest(conn net.Conn, params PeerConnectionParams) {
-	defer conn.Close()
-	logger := params.logger
+// handleMetadataRequest reports on metadataRequests whether the client requested the metadata
+func handleMetadataRequest(metadataRequests chan<- bool) ConnectionHandler {
+	return func(conn net.Conn, params PeerConnectionParams) (err error) {
+		defer func() { reportHandlerOutcome(metadataRequests, err) }()
+		defer conn.Close()
+		logger := params.logger
 
-	if err := receiveAndSendHandshake(conn, params); err != nil {
-		return
-	}
+		if err := receiveAndSendHandshake(conn, params); err != nil {
+			return err
+		}
 
-	if err := sendBitfieldMessage(conn, params.bitfield, logger); err != nil {
-		return
-	}
+		if err := sendBitfieldMessage(conn, params.bitfield, logger); err != nil {
+			return err
+		}
 
-	if err := sendExtensionHandshake(conn, params.myMetadataExtensionID, params.metadataSizeBytes, logger); err != nil {
-		return
-	}
+		if err := sendExtensionHandshake(conn, params.myMetadataExtensionID, params.metadataSizeBytes, logger); err != nil {
+			return err
+		}
 
-	theirMetadataExtensionID, err := receiveAndAssertExtensionHandshake(conn, logger)
-	if err != nil {
-		return
-	}
+		theirMetadataExtensionID, err := receiveAndAssertExtensionHandshake(conn, logger)
+		if err != nil {
+			return err
+		}
 
-	if err := readMetadataRequest(conn, logger); err != nil {
-		return
-	}
+		if err := readMetadataRequest(conn, logger); err != nil {
+			return err
+		}
 
-	// Send in case other party is waiting for this to terminate
-	sendMetadataResponse(conn, theirMetadataExtensionID, params.magnetLink, logger)
+		// Send in case other party is waiting for this to terminate
+		sendMetadataResponse(conn, theirMetadataExtensionID, params.magnetLink, logger)
 
-	metadataRequestChannel <- true
+		return nil
+	}
 }
 
 func readMetadataRequest(conn net.Conn, logger *logger.Logger) (err error) {
@@ -158,3 +160,11 @@ func sendMetadataResponse(conn net.Conn, metadataExtensionID uint8, magnetLink M
 	}
 	return nil
 }
+
+// reportHandlerOutcome reports whether a connection handler succeeded, unless an earlier connection already did
+func reportHandlerOutcome(outcomes chan<- bool, err error) {
+	select {
+	case outcomes <- err == nil:
+	default:
+	}
+}
@@ -10,7 +10,7 @@ import (
 func testMagnetSendMetadata(stageHarness *test_case_harness.TestCaseHarness) error {
 
 	logger := stageHarness.Logger
-	executable := stageHarness.Executable
+	executable := newRecordingExecutable(stageHarness)
 
 	magnetLink := randomMagnetLink()
 	params, err := NewMagnetTestParams(magnetLink, logger)
@@ -18,8 +18,8 @@ func testMagnetSendMetadata(stageHarness *test_case_harness.TestCaseHarness) err
 		return err
 	}
 
-	go listenAndServeTrackerResponse(params.toTrackerParams())
-	go waitAndHandlePeerConnection(params.toPeerConnectionParams(), handleSendMetadata)
+	startTracker(params.toTrackerParams())
+	startPeer(params.toPeerConnectionParams(), handleSendMetadata)
 
 	logger.Infof("Running ./your_bittorrent.sh magnet_info %q", params.MagnetUrlEncoded)
 	result, err := executable.Run("magnet_info", params.MagnetUrlEncoded)
@@ -71,33 +71,35 @@ func testMagnetSendMetadata(stageHarness *test_case_harness.TestCaseHarness) err
 	return nil
 }
 
-func handleSendMetadata(conn net.Conn, params PeerConnectionParams) {
+func handleSendMetadata(conn net.Conn, params PeerConnectionParams) error {
 	defer conn.Close()
 	logger := params.logger
 
 	if err := receiveAndSendHandshake(conn, params); err != nil {
-		return
+		return err
 	}
 
 	if err := sendBitfieldMessage(conn, params.bitfield, logger); err != nil {
-		return
+		return err
 	}
 
 	if err := sendExtensionHandshake(conn, params.myMetadataExtensionID, params.metadataSizeBytes, logger); err != nil {
-		return
+		return err
 	}
 
 	theirMetadataExtensionID, err := receiveAndAssertExtensionHandshake(conn, logger)
 	if err != nil {
-		return
+		return err
 	}
 
 	if err := readMetadataRequest(conn, logger); err != nil {
-		return
+		return err
 	}
 
 	if err := sendMetadataResponse(conn, theirMetadataExtensionID, params.magnetLink, logger); err != nil {
 		logger.Errorln(err.Error())
-		return
+		return err
 	}
+
+	return nil
 }
@@ -12,7 +12,7 @@ func testParseMagnetLink(stageHarness *test_case_harness.TestCaseHarness) error
 	urlEncoded := "magnet:?xt=urn:btih:" + link.InfoHashStr + "&dn=" + link.Filename + "&tr=http%3A%2F%2Fbittorrent-test-tracker.codecrafters.io%2Fannounce"
 
 	logger := stageHarness.Logger
-	executable := stageHarness.Executable
+	executable := newRecordingExecutable(stageHarness)
 
 	logger.Infof("Running ./your_bittorrent.sh magnet_parse %q", urlEncoded)
 	result, err := executable.Run("magnet_parse", urlEncoded)
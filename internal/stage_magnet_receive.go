@@ -10,7 +10,7 @@ import (
 
 func testMagnetReceiveExtendedHandshake(stageHarness *test_case_harness.TestCaseHarness) error {
 	logger := stageHarness.Logger
-	executable := stageHarness.Executable
+	executable := newRecordingExecutable(stageHarness)
 
 	magnetLink := randomMagnetLink()
 	params, err := NewMagnetTestParams(magnetLink, logger)
@@ -18,8 +18,8 @@ func testMagnetReceiveExtendedHandshake(stageHarness *test_case_harness.TestCase
 		return err
 	}
 
-	go listenAndServeTrackerResponse(params.toTrackerParams())
-	go waitAndHandlePeerConnection(params.toPeerConnectionParams(), handleReceiveExtensionHandshake)
+	startTracker(params.toTrackerParams())
+	startPeer(params.toPeerConnectionParams(), handleReceiveExtensionHandshake)
 
 	logger.Infof("Running ./your_bittorrent.sh magnet_handshake %q", params.MagnetUrlEncoded)
 	result, err := executable.Run("magnet_handshake", params.MagnetUrlEncoded)
@@ -50,26 +50,27 @@ func testMagnetReceiveExtendedHandshake(stageHarness *test_case_harness.TestCase
 	return nil
 }
 
-func handleReceiveExtensionHandshake(conn net.Conn, p PeerConnectionParams) {
+func handleReceiveExtensionHandshake(conn net.Conn, p PeerConnectionParams) error {
 	defer conn.Close()
 	logger := p.logger
 
 	if err := receiveAndSendHandshake(conn, p); err != nil {
-		return
+		return err
 	}
 
 	if err := sendBitfieldMessage(conn, p.bitfield, logger); err != nil {
-		return
+		return err
 	}
 
 	if err := sendExtensionHandshake(conn, p.myMetadataExtensionID, p.metadataSizeBytes, logger); err != nil {
-		return
+		return err
 	}
 
 	if _, err := receiveAndAssertExtensionHandshake(conn, logger); err != nil {
-		return
+		return err
 	}
 
 	// Wait in case other party wants to send extra data
 	time.Sleep(1 * time.Second)
+	return nil
 }
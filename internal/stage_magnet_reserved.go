@@ -12,7 +12,7 @@ import (
 func testMagnetReserved(stageHarness *test_case_harness.TestCaseHarness) error {
 
 	logger := stageHarness.Logger
-	executable := stageHarness.Executable
+	executable := newRecordingExecutable(stageHarness)
 
 	magnetLink := randomMagnetLink()
 	params, err := NewMagnetTestParams(magnetLink, logger)
@@ -20,8 +20,8 @@ func testMagnetReserved(stageHarness *test_case_harness.TestCaseHarness) error {
 		return err
 	}
 
-	go listenAndServeTrackerResponse(params.toTrackerParams())
-	go waitAndHandlePeerConnection(params.toPeerConnectionParams(), handleReservedBytes)
+	startTracker(params.toTrackerParams())
+	startPeer(params.toPeerConnectionParams(), handleReservedBytes)
 
 	logger.Infof("Running ./your_bittorrent.sh magnet_handshake %q", params.MagnetUrlEncoded)
 	result, err := executable.Run("magnet_handshake", params.MagnetUrlEncoded)
@@ -52,18 +52,16 @@ func closeConnection(conn net.Conn, logger *logger.Logger) {
 	}
 }
 
-func handleReservedBytes(conn net.Conn, p PeerConnectionParams) {
+func handleReservedBytes(conn net.Conn, p PeerConnectionParams) error {
 	defer closeConnection(conn, p.logger)
 
 	if err := receiveAndSendHandshake(conn, p); err != nil {
-		return
+		return err
 	}
 
 	if err := sendBitfieldMessage(conn, p.bitfield, p.logger); err != nil {
-		return
+		return err
 	}
 
-	if err := sendExtensionHandshake(conn, p.myMetadataExtensionID, p.metadataSizeBytes, p.logger); err != nil {
-		return
-	}
+	return sendExtensionHandshake(conn, p.myMetadataExtensionID, p.metadataSizeBytes, p.logger)
 }
@@ -8,11 +8,9 @@ import (
 	"github.com/codecrafters-io/tester-utils/test_case_harness"
 )
 
-var handshakeChannel = make(chan bool)
-
 func testMagnetSendExtendedHandshake(stageHarness *test_case_harness.TestCaseHarness) error {
 	logger := stageHarness.Logger
-	executable := stageHarness.Executable
+	executable := newRecordingExecutable(stageHarness)
 
 	magnetLink := randomMagnetLink()
 	params, err := NewMagnetTestParams(magnetLink, logger)
@@ -20,8 +18,9 @@ func testMagnetSendExtendedHandshake(stageHarness *test_case_harness.TestCaseHar
 		return err
 	}
 
-	go listenAndServeTrackerResponse(params.toTrackerParams())
-	go waitAndHandlePeerConnection(params.toPeerConnectionParams(), handleSendExtensionHandshake)
+	handshakes := make(chan bool, 1)
+	startTracker(params.toTrackerParams())
+	startPeer(params.toPeerConnectionParams(), handleSendExtensionHandshake(handshakes))
 
 	logger.Infof("Running ./your_bittorrent.sh magnet_handshake %q", params.MagnetUrlEncoded)
 	result, err := executable.Run("magnet_handshake", params.MagnetUrlEncoded)
@@ -39,33 +38,36 @@ func testMagnetSendExtendedHandshake(stageHarness *test_case_harness.TestCaseHar
 		return err
 	}
 
-	success := <-handshakeChannel
-	if success {
+	if success := <-handshakes; success {
 		return nil
 	}
 
 	return errors.New("extension handshake was not received")
 }
 
-func handleSendExtensionHandshake(conn net.Conn, params PeerConnectionParams) {
-	defer conn.Close()
-	logger := params.logger
+// handleSendExtensionHandshake reports on handshakes whether the client sent its extension handshake
+func handleSendExtensionHandshake(handshakes chan<- bool) ConnectionHandler {
+	return func(conn net.Conn, params PeerConnectionParams) (err error) {
+		defer func() { reportHandlerOutcome(handshakes, err) }()
+		defer conn.Close()
+		logger := params.logger
 
-	if err := receiveAndSendHandshake(conn, params); err != nil {
-		return
-	}
+		if err := receiveAndSendHandshake(conn, params); err != nil {
+			return err
+		}
 
-	if err := sendBitfieldMessage(conn, params.bitfield, logger); err != nil {
-		return
-	}
+		if err := sendBitfieldMessage(conn, params.bitfield, logger); err != nil {
+			return err
+		}
 
-	if err := sendExtensionHandshake(conn, params.myMetadataExtensionID, params.metadataSizeBytes, logger); err != nil {
-		return
-	}
+		if err := sendExtensionHandshake(conn, params.myMetadataExtensionID, params.metadataSizeBytes, logger); err != nil {
+			return err
+		}
 
-	if _, err := receiveAndAssertExtensionHandshake(conn, logger); err != nil {
-		return
-	}
+		if _, err := receiveAndAssertExtensionHandshake(conn, logger); err != nil {
+			return err
+		}
 
-	handshakeChannel <- true
+		return nil
+	}
 }
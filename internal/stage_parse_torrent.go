@@ -11,7 +11,7 @@ import (
 
 func testParseTorrent(stageHarness *test_case_harness.TestCaseHarness) error {
 	logger := stageHarness.Logger
-	executable := stageHarness.Executable
+	executable := newRecordingExecutable(stageHarness)
 	torrent := randomTorrent()
 
 	tempDir, err := os.MkdirTemp("", "torrents")
@@ -62,7 +62,7 @@ func randomResponse() DiscoverPeersTestCase {
 
 func testDiscoverPeers(stageHarness *test_case_harness.TestCaseHarness) error {
 	logger := stageHarness.Logger
-	executable := stageHarness.Executable
+	executable := newRecordingExecutable(stageHarness)
 
 	tempDir, err := os.MkdirTemp("", "torrents")
 	if err != nil {
@@ -110,7 +110,7 @@ func testDiscoverPeers(stageHarness *test_case_harness.TestCaseHarness) error {
 		return err
 	}
 
-	go listenAndServeTrackerResponse(TrackerParams{
+	startTracker(TrackerParams{
 		trackerAddress:   address,
 		peersResponse:    peersResponse,
 		expectedInfoHash: expectedInfoHash,
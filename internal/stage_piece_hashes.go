@@ -13,7 +13,7 @@ import (
 
 func testPieceHashes(stageHarness *test_case_harness.TestCaseHarness) error {
 	logger := stageHarness.Logger
-	executable := stageHarness.Executable
+	executable := newRecordingExecutable(stageHarness)
 
 	torrentFilename := "test.torrent"
 	tempDir, err := os.MkdirTemp("", "torrents")
@@ -0,0 +1,244 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strings"
+
+	logger "github.com/codecrafters-io/tester-utils/logger"
+	"github.com/codecrafters-io/tester-utils/random"
+	"github.com/codecrafters-io/tester-utils/test_case_harness"
+	"github.com/jackpal/bencode-go"
+)
+
+const myPEXExtensionID uint8 = 1
+
+func testPrivateTorrent(stageHarness *test_case_harness.TestCaseHarness) error {
+	logger := stageHarness.Logger
+	executable := newRecordingExecutable(stageHarness)
+
+	tempDir, err := os.MkdirTemp("", "torrents")
+	if err != nil {
+		logger.Errorln("Couldn't create temp directory")
+		return err
+	}
+
+	peerPort, err := findFreePort()
+	if err != nil {
+		logger.Errorf("Couldn't find free port: %s", err)
+		return err
+	}
+
+	trackerPort, err := findFreePort()
+	if err != nil {
+		logger.Errorf("Couldn't find free port: %s", err)
+		return err
+	}
+	trackerAddress := fmt.Sprintf("127.0.0.1:%d", trackerPort)
+
+	pieceLengthBytes := 32 * 1024
+	content := randomBytes(pieceLengthBytes*random.RandomInt(2, 5) + random.RandomInt(1, pieceLengthBytes))
+	source := strings.ToUpper(random.RandomWord())
+	torrent := TorrentFile{
+		Announce: fmt.Sprintf("http://%s/announce", trackerAddress),
+		Info: TorrentFileInfo{
+			Name:        fmt.Sprintf("%s.bin", random.RandomWord()),
+			Length:      len(content),
+			Pieces:      createPiecesStrFromBytes(content, pieceLengthBytes),
+			PieceLength: pieceLengthBytes,
+			Private:     1,
+			Source:      source,
+		},
+	}
+
+	torrentFilePath := path.Join(tempDir, "private.torrent")
+	infoHash, err := torrent.writeToFile(torrentFilePath)
+	if err != nil {
+		logger.Errorf("Error writing torrent file: %s", err)
+		return err
+	}
+
+	logger.Infof("Running ./%s info %s", path.Base(executable.Path), torrentFilePath)
+	result, err := executable.Run("info", torrentFilePath)
+	if err != nil {
+		return err
+	}
+
+	if err = assertExitCode(result, 0); err != nil {
+		return err
+	}
+
+	if err = assertStdoutContains(result, "Private: 1\n"); err != nil {
+		return err
+	}
+
+	if err = assertStdoutContains(result, fmt.Sprintf("Source: %s\n", source)); err != nil {
+		return err
+	}
+
+	if err = assertStdoutContains(result, fmt.Sprintf("Info Hash: %x\n", infoHash)); err != nil {
+		logInfoHashMistake(logger, torrentFilePath, result.Stdout)
+		return err
+	}
+
+	logger.Successln("✓ Private torrent info is correct.")
+
+	peerID, err := randomHash()
+	if err != nil {
+		return err
+	}
+
+	startTracker(TrackerParams{
+		trackerAddress:   trackerAddress,
+		peersResponse:    createPeersResponse("127.0.0.1", peerPort),
+		expectedInfoHash: infoHash,
+		fileLengthBytes:  len(content),
+		logger:           logger,
+	})
+
+	pieces := splitIntoPieces(content, pieceLengthBytes)
+	violations := make(chan string, 1)
+	startPeer(
+		PeerConnectionParams{
+			address:  fmt.Sprintf("127.0.0.1:%d", peerPort),
+			myPeerID: peerID,
+			infoHash: infoHash,
+			expectedReservedBytes: [][]byte{
+				{0, 0, 0, 0, 0, 0, 0, 0},
+				{0, 0, 0, 0, 0, 16, 0, 0},
+			},
+			// Advertise extension protocol and DHT support to tempt the client
+			myReservedBytes:  []byte{0, 0, 0, 0, 0, 16, 0, 1},
+			bitfield:         fullBitfield(len(pieces)),
+			pieces:           pieces,
+			pieceLengthBytes: pieceLengthBytes,
+			isPrivateTorrent: true,
+			logger:           logger,
+		},
+		handlePrivateTorrentPeer(violations),
+	)
+
+	pieceIndex := random.RandomInt(0, len(pieces))
+	downloadedFilePath := path.Join(tempDir, fmt.Sprintf("piece-%d", pieceIndex))
+	logger.Infof("Running ./%s download_piece -o %s %s %d", path.Base(executable.Path), downloadedFilePath, torrentFilePath, pieceIndex)
+	result, err = executable.Run("download_piece", "-o", downloadedFilePath, torrentFilePath, fmt.Sprintf("%d", pieceIndex))
+	if err != nil {
+		return err
+	}
+
+	select {
+	case violation := <-violations:
+		return errors.New(violation)
+	default:
+	}
+
+	if err = assertExitCode(result, 0); err != nil {
+		return err
+	}
+
+	if err = assertFileSize(downloadedFilePath, int64(len(pieces[pieceIndex]))); err != nil {
+		return err
+	}
+
+	if err = assertFileSHA1(downloadedFilePath, fmt.Sprintf("%x", sha1.Sum(pieces[pieceIndex]))); err != nil {
+		return err
+	}
+
+	logger.Successln("✓ Piece downloaded without using DHT or PEX.")
+
+	return nil
+}
+
+// reportPrivateTorrentViolation keeps the first violation, later ones are dropped
+func reportPrivateTorrentViolation(violations chan<- string, violation string) {
+	select {
+	case violations <- violation:
+	default:
+	}
+}
+
+func handlePrivateTorrentPeer(violations chan<- string) ConnectionHandler {
+	return func(conn net.Conn, params PeerConnectionParams) error {
+		defer conn.Close()
+
+		logger := params.logger
+		clientReservedBytes, err := exchangeHandshake(conn, params)
+		if err != nil {
+			return err
+		}
+
+		if err := sendBitfieldMessage(conn, params.bitfield, logger); err != nil {
+			return err
+		}
+
+		// BEP 5 only allows port messages to clients that set the DHT bit, which the handshake rejects for private torrents
+		logger.Infoln("Not offering a DHT node, your handshake doesn't advertise DHT support")
+
+		// BEP 10 only allows extension messages to clients that set the extension bit
+		if clientReservedBytes[5]&0x10 != 0 {
+			if err := sendPEXExtensionHandshake(conn, logger); err != nil {
+				return err
+			}
+		} else {
+			logger.Infoln("Not offering ut_pex, your handshake doesn't advertise extension protocol support")
+		}
+
+		return servePieces(conn, params.pieces, logger, func(conn net.Conn, msg *Message) (bool, error) {
+			if msg.ID != MsgExtended {
+				return false, nil
+			}
+			return true, handlePrivateTorrentExtensionMessage(conn, msg, params, violations)
+		})
+	}
+}
+
+func handlePrivateTorrentExtensionMessage(conn net.Conn, msg *Message, params PeerConnectionParams, violations chan<- string) error {
+	logger := params.logger
+
+	if len(msg.Payload) < 1 {
+		return errors.New("extension message payload is empty")
+	}
+
+	if msg.Payload[0] == myPEXExtensionID {
+		reportPrivateTorrentViolation(violations, "Received a ut_pex message. Clients must not exchange peers for private torrents")
+		return errors.New("received ut_pex message for a private torrent")
+	}
+
+	if msg.Payload[0] != HandshakeExtendedID {
+		logger.Debugf("Ignoring extension message with id: %d", msg.Payload[0])
+		return nil
+	}
+
+	logger.Debugf("Received extension handshake with payload: %s", string(msg.Payload[1:]))
+	decoded, err := bencode.Decode(bytes.NewReader(msg.Payload[1:]))
+	if err != nil {
+		return fmt.Errorf("error decoding extension handshake: %v", err)
+	}
+	if dict, ok := decoded.(map[string]interface{}); ok {
+		if m, ok := dict["m"].(map[string]interface{}); ok {
+			if id, exists := m["ut_pex"]; exists && id != int64(0) {
+				reportPrivateTorrentViolation(violations, "Your extension handshake advertises ut_pex. Clients must not support peer exchange for private torrents")
+				return errors.New("extension handshake advertises ut_pex for a private torrent")
+			}
+		}
+	}
+
+	return nil
+}
+
+func sendPEXExtensionHandshake(conn net.Conn, logger *logger.Logger) error {
+	var payload bytes.Buffer
+	payload.WriteByte(HandshakeExtendedID)
+	if err := bencode.Marshal(&payload, map[string]interface{}{
+		"m": map[string]interface{}{"ut_pex": int(myPEXExtensionID)},
+	}); err != nil {
+		return err
+	}
+	logger.Debugln("Sending extension handshake offering ut_pex")
+	return sendMessage(conn, &Message{ID: MsgExtended, Payload: payload.Bytes()})
+}
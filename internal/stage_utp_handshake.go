@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/codecrafters-io/tester-utils/test_case_harness"
+)
+
+func testUTPHandshake(stageHarness *test_case_harness.TestCaseHarness) error {
+	logger := stageHarness.Logger
+	executable := newRecordingExecutable(stageHarness)
+
+	tempDir, err := os.MkdirTemp("", "torrents")
+	if err != nil {
+		return err
+	}
+
+	peerPort, err := findFreePort()
+	if err != nil {
+		logger.Errorf("Couldn't find free port: %s", err)
+		return err
+	}
+	peerAddress := fmt.Sprintf("127.0.0.1:%d", peerPort)
+
+	pieceLengthBytes := 1024 * 256
+	fileLengthBytes := pieceLengthBytes * len(samplePieceHashes)
+	torrent := TorrentFile{
+		// The peer address is passed on the command line, so the tracker isn't contacted
+		Announce: "http://bittorrent-test-tracker.codecrafters.io/announce",
+		Info: TorrentFileInfo{
+			Name:        "fakefilename.iso",
+			Length:      fileLengthBytes,
+			Pieces:      toPiecesStr(samplePieceHashes),
+			PieceLength: pieceLengthBytes,
+		},
+	}
+
+	torrentFilePath := path.Join(tempDir, "test.torrent")
+	infoHash, err := torrent.writeToFile(torrentFilePath)
+	if err != nil {
+		logger.Errorf("Error writing torrent file: %s", err)
+		return err
+	}
+
+	expectedPeerID, err := randomHash()
+	if err != nil {
+		return err
+	}
+
+	startUTPPeer(
+		PeerConnectionParams{
+			address:  peerAddress,
+			myPeerID: expectedPeerID,
+			infoHash: infoHash,
+			expectedReservedBytes: [][]byte{
+				{0, 0, 0, 0, 0, 0, 0, 0},
+				{0, 0, 0, 0, 0, 16, 0, 0},
+			},
+			logger: logger,
+		},
+		handleHandshake,
+	)
+
+	logger.Infoln("This peer is only reachable over uTP (UDP), TCP connections will be refused")
+	logger.Infof("Running ./%s handshake %s %s", path.Base(executable.Path), torrentFilePath, peerAddress)
+	result, err := executable.Run("handshake", torrentFilePath, peerAddress)
+	if err != nil {
+		return err
+	}
+
+	if err = assertExitCode(result, 0); err != nil {
+		return err
+	}
+
+	expected := fmt.Sprintf("Peer ID: %x\n", expectedPeerID)
+
+	if err = assertStdoutContains(result, expected); err != nil {
+		return err
+	}
+
+	return nil
+}
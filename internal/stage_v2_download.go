@@ -0,0 +1,186 @@
+package internal
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path"
+
+	"github.com/codecrafters-io/tester-utils/random"
+	"github.com/codecrafters-io/tester-utils/test_case_harness"
+)
+
+func testV2DownloadFile(stageHarness *test_case_harness.TestCaseHarness) error {
+	logger := stageHarness.Logger
+	executable := newRecordingExecutable(stageHarness)
+
+	tempDir, err := os.MkdirTemp("", "torrents")
+	if err != nil {
+		logger.Errorln("Couldn't create temp directory")
+		return err
+	}
+
+	peerPort, err := findFreePort()
+	if err != nil {
+		logger.Errorf("Couldn't find free port: %s", err)
+		return err
+	}
+
+	trackerPort, err := findFreePort()
+	if err != nil {
+		logger.Errorf("Couldn't find free port: %s", err)
+		return err
+	}
+	trackerAddress := fmt.Sprintf("127.0.0.1:%d", trackerPort)
+
+	pieceLengthBytes := 32 * 1024
+	name := fmt.Sprintf("%s.bin", random.RandomWord())
+	content := randomBytes(pieceLengthBytes*random.RandomInt(3, 7) + random.RandomInt(1, pieceLengthBytes))
+	torrent, merkleTrees, err := newTorrentFileV2(fmt.Sprintf("http://%s/announce", trackerAddress), name, pieceLengthBytes, []TorrentFileV2File{
+		{Path: []string{name}, Content: content},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Without piece layers, the client has to request them from the peer
+	torrent.PieceLayers = nil
+
+	torrentFilePath := path.Join(tempDir, "test.torrent")
+	infoHash, err := torrent.writeToFile(torrentFilePath)
+	if err != nil {
+		logger.Errorf("Error writing torrent file: %s", err)
+		return err
+	}
+
+	peerID, err := randomHash()
+	if err != nil {
+		return err
+	}
+
+	startTracker(TrackerParams{
+		trackerAddress:   trackerAddress,
+		peersResponse:    createPeersResponse("127.0.0.1", peerPort),
+		expectedInfoHash: truncatedInfoHash(infoHash),
+		torrentVersion:   torrentVersionV2,
+		fileLengthBytes:  len(content),
+		logger:           logger,
+	})
+
+	pieces := splitIntoPieces(content, pieceLengthBytes)
+	hashRequests := make(chan bool, 1)
+	startPeer(
+		PeerConnectionParams{
+			address:  fmt.Sprintf("127.0.0.1:%d", peerPort),
+			myPeerID: peerID,
+			infoHash: truncatedInfoHash(infoHash),
+			expectedReservedBytes: [][]byte{
+				{0, 0, 0, 0, 0, 0, 0, 16},
+				{0, 0, 0, 0, 0, 16, 0, 16},
+			},
+			bitfield:         fullBitfield(len(pieces)),
+			pieces:           pieces,
+			pieceLengthBytes: pieceLengthBytes,
+			merkleTrees:      merkleTrees,
+			isV2Torrent:      true,
+			logger:           logger,
+		},
+		handleV2Download(hashRequests),
+	)
+
+	downloadedFilePath := path.Join(tempDir, name)
+	logger.Infoln("The torrent file doesn't contain piece layers, request them from the peer with hash request messages")
+	logger.Infof("Running ./%s download -o %s %s", path.Base(executable.Path), downloadedFilePath, torrentFilePath)
+	result, err := executable.Run("download", "-o", downloadedFilePath, torrentFilePath)
+	if err != nil {
+		return err
+	}
+
+	if err = assertExitCode(result, 0); err != nil {
+		return err
+	}
+
+	select {
+	case <-hashRequests:
+		logger.Successln("✓ Received hash request for the piece layer.")
+	default:
+		return fmt.Errorf("Expected a hash request message (id %d) for the piece layer, but none was received", MsgHashRequest)
+	}
+
+	if err = assertFileSize(downloadedFilePath, int64(len(content))); err != nil {
+		return err
+	}
+
+	if err = assertFileSHA1(downloadedFilePath, fmt.Sprintf("%x", sha1.Sum(content))); err != nil {
+		return err
+	}
+
+	logger.Successln("✓ File SHA-1 is correct.")
+
+	return nil
+}
+
+// handleV2Download serves pieces and piece layer hashes, and reports on hashRequests when hashes were requested
+func handleV2Download(hashRequests chan<- bool) ConnectionHandler {
+	return func(conn net.Conn, params PeerConnectionParams) error {
+		defer conn.Close()
+
+		if err := receiveAndSendHandshake(conn, params); err != nil {
+			return err
+		}
+
+		if err := sendBitfieldMessage(conn, params.bitfield, params.logger); err != nil {
+			return err
+		}
+
+		return servePieces(conn, params.pieces, params.logger, func(conn net.Conn, msg *Message) (bool, error) {
+			if msg.ID != MsgHashRequest {
+				return false, nil
+			}
+			return true, serveHashRequest(conn, msg, params, hashRequests)
+		})
+	}
+}
+
+func serveHashRequest(conn net.Conn, msg *Message, params PeerConnectionParams, hashRequests chan<- bool) error {
+	logger := params.logger
+
+	// <pieces root (32 bytes)><base layer><index><length><proof layers>
+	if len(msg.Payload) != 48 {
+		return fmt.Errorf("hash request payload needs to be 48 bytes long, got %d bytes", len(msg.Payload))
+	}
+
+	var piecesRoot [32]byte
+	copy(piecesRoot[:], msg.Payload[0:32])
+	baseLayer := int(binary.BigEndian.Uint32(msg.Payload[32:36]))
+	index := int(binary.BigEndian.Uint32(msg.Payload[36:40]))
+	length := int(binary.BigEndian.Uint32(msg.Payload[40:44]))
+	proofLayers := int(binary.BigEndian.Uint32(msg.Payload[44:48]))
+	logger.Debugf("Received hash request for pieces root %x, base layer: %d, index: %d, length: %d, proof layers: %d", piecesRoot, baseLayer, index, length, proofLayers)
+
+	select {
+	case hashRequests <- true:
+	default:
+	}
+
+	tree, exists := params.merkleTrees[piecesRoot]
+	if !exists {
+		logger.Errorf("Unknown pieces root in hash request: %x, sending hash reject", piecesRoot)
+		return sendMessage(conn, &Message{ID: MsgHashReject, Payload: msg.Payload})
+	}
+
+	hashes, err := tree.proof(baseLayer, index, length, proofLayers)
+	if err != nil {
+		logger.Errorf("Invalid hash request: %s. The piece layer is layer %d for a piece length of %d, sending hash reject", err, pieceLayerIndex(params.pieceLengthBytes), params.pieceLengthBytes)
+		return sendMessage(conn, &Message{ID: MsgHashReject, Payload: msg.Payload})
+	}
+
+	payload := append([]byte{}, msg.Payload...)
+	for _, hash := range hashes {
+		payload = append(payload, hash[:]...)
+	}
+	logger.Debugf("Sending %d hashes", len(hashes))
+	return sendMessage(conn, &Message{ID: MsgHashes, Payload: payload})
+}
@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/codecrafters-io/tester-utils/random"
+	"github.com/codecrafters-io/tester-utils/test_case_harness"
+)
+
+func testV2FileTree(stageHarness *test_case_harness.TestCaseHarness) error {
+	logger := stageHarness.Logger
+	executable := newRecordingExecutable(stageHarness)
+
+	tempDir, err := os.MkdirTemp("", "torrents")
+	if err != nil {
+		return err
+	}
+
+	pieceLengthBytes := 32 * 1024
+	words := random.RandomWords(4)
+	files := []TorrentFileV2File{
+		{Path: []string{words[0] + ".txt"}},
+		{Path: []string{"docs", words[1] + ".txt"}},
+		{Path: []string{"docs", words[2], words[3] + ".bin"}},
+	}
+	for i := range files {
+		files[i].Content = randomBytes(random.RandomInt(1, 3*pieceLengthBytes))
+	}
+
+	torrent, _, err := newTorrentFileV2("http://bittorrent-test-tracker.codecrafters.io/announce", random.RandomWord(), pieceLengthBytes, files)
+	if err != nil {
+		return err
+	}
+
+	torrentPath := path.Join(tempDir, "test.torrent")
+	if _, err := torrent.writeToFile(torrentPath); err != nil {
+		logger.Errorf("Error writing torrent file: %s", err)
+		return err
+	}
+
+	logger.Infof("Running ./%s info %s", path.Base(executable.Path), torrentPath)
+	result, err := executable.Run("info", torrentPath)
+	if err != nil {
+		return err
+	}
+
+	if err = assertExitCode(result, 0); err != nil {
+		return err
+	}
+
+	expected := fmt.Sprintf("Piece Length: %d\n", pieceLengthBytes)
+	if err = assertStdoutContains(result, expected); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		filePath := strings.Join(file.Path, "/")
+		expected := fmt.Sprintf("File: %s, Length: %d, Pieces Root: %x\n", filePath, len(file.Content), newMerkleTree(file.Content).root())
+		logger.Debugf("Checking for %q", strings.TrimSpace(expected))
+		if err = assertStdoutContains(result, expected); err != nil {
+			if !strings.Contains(string(result.Stdout), filePath) {
+				logger.Errorf("File %s is missing. Directories in the file tree are nested dictionaries, and files are entries with an empty string key", filePath)
+			}
+			return err
+		}
+	}
+
+	logger.Successln("✓ File tree is correct.")
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/codecrafters-io/tester-utils/random"
+	"github.com/codecrafters-io/tester-utils/test_case_harness"
+)
+
+func testV2InfoHash(stageHarness *test_case_harness.TestCaseHarness) error {
+	logger := stageHarness.Logger
+	executable := newRecordingExecutable(stageHarness)
+
+	tempDir, err := os.MkdirTemp("", "torrents")
+	if err != nil {
+		return err
+	}
+
+	pieceLengthBytes := 32 * 1024
+	name := fmt.Sprintf("%s.bin", random.RandomWord())
+	content := randomBytes(random.RandomInt(pieceLengthBytes, 4*pieceLengthBytes))
+	torrent, _, err := newTorrentFileV2("http://bittorrent-test-tracker.codecrafters.io/announce", name, pieceLengthBytes, []TorrentFileV2File{
+		{Path: []string{name}, Content: content},
+	})
+	if err != nil {
+		return err
+	}
+
+	isHybrid := random.RandomInt(0, 2) == 0
+	if isHybrid {
+		torrent.Info.Length = len(content)
+		torrent.Info.Pieces = createPiecesStrFromBytes(content, pieceLengthBytes)
+		logger.Infoln("Generated a hybrid torrent, which has both v1 and v2 metadata")
+	} else {
+		logger.Infoln("Generated a v2-only torrent")
+	}
+
+	torrentPath := path.Join(tempDir, "test.torrent")
+	infoHash, err := torrent.writeToFile(torrentPath)
+	if err != nil {
+		logger.Errorf("Error writing torrent file: %s", err)
+		return err
+	}
+	infoHashV1, err := torrent.Info.hashV1()
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Running ./%s info %s", path.Base(executable.Path), torrentPath)
+	result, err := executable.Run("info", torrentPath)
+	if err != nil {
+		return err
+	}
+
+	if err = assertExitCode(result, 0); err != nil {
+		return err
+	}
+
+	expected := fmt.Sprintf("Info Hash v2: %x\n", infoHash)
+	if err = assertStdoutContains(result, expected); err != nil {
+		output := string(result.Stdout)
+		if strings.Contains(output, fmt.Sprintf("%x", infoHashV1)) {
+			logger.Errorln("That looks like the SHA-1 of the info dictionary. The v2 info hash is the SHA-256 of the bencoded info dictionary")
+		}
+		return err
+	}
+
+	logger.Successln("✓ Info Hash v2 is correct.")
+
+	expected = fmt.Sprintf("Info Hash v2 (truncated): %x\n", truncatedInfoHash(infoHash))
+	if err = assertStdoutContains(result, expected); err != nil {
+		return err
+	}
+
+	logger.Successln("✓ Truncated Info Hash v2 is correct.")
+
+	if isHybrid {
+		expected = fmt.Sprintf("Info Hash: %x\n", infoHashV1)
+		if err = assertStdoutContains(result, expected); err != nil {
+			return err
+		}
+
+		logger.Successln("✓ Info Hash (v1) is correct.")
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package internal
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+
+	"github.com/codecrafters-io/tester-utils/random"
+	"github.com/codecrafters-io/tester-utils/test_case_harness"
+)
+
+func testWebSeedDownload(stageHarness *test_case_harness.TestCaseHarness) error {
+	logger := stageHarness.Logger
+	executable := newRecordingExecutable(stageHarness)
+
+	tempDir, err := os.MkdirTemp("", "torrents")
+	if err != nil {
+		logger.Errorln("Couldn't create temp directory")
+		return err
+	}
+
+	trackerPort, err := findFreePort()
+	if err != nil {
+		logger.Errorf("Couldn't find free port: %s", err)
+		return err
+	}
+	trackerAddress := fmt.Sprintf("127.0.0.1:%d", trackerPort)
+
+	webSeedPort, err := findFreePort()
+	if err != nil {
+		logger.Errorf("Couldn't find free port: %s", err)
+		return err
+	}
+	webSeedAddress := fmt.Sprintf("127.0.0.1:%d", webSeedPort)
+
+	filename := fmt.Sprintf("%s.bin", random.RandomWord())
+	pieceLengthBytes := 32 * 1024
+	fileLengthBytes := pieceLengthBytes*random.RandomInt(3, 7) + random.RandomInt(1, pieceLengthBytes)
+	content := randomBytes(fileLengthBytes)
+
+	torrent := TorrentFile{
+		Announce: fmt.Sprintf("http://%s/announce", trackerAddress),
+		Info: TorrentFileInfo{
+			Name:        filename,
+			Length:      fileLengthBytes,
+			Pieces:      createPiecesStrFromBytes(content, pieceLengthBytes),
+			PieceLength: pieceLengthBytes,
+		},
+		URLList: []string{fmt.Sprintf("http://%s/files/%s", webSeedAddress, filename)},
+	}
+
+	torrentFilePath := path.Join(tempDir, "test.torrent")
+	infoHash, err := torrent.writeToFile(torrentFilePath)
+	if err != nil {
+		logger.Errorf("Error writing torrent file: %s", err)
+		return err
+	}
+
+	peersResponse := createEmptyPeersResponse()
+	if random.RandomInt(0, 2) == 0 {
+		logger.Infoln("The tracker will return no peers, the file is only available from the web seed")
+	} else {
+		peerPort, err := findFreePort()
+		if err != nil {
+			logger.Errorf("Couldn't find free port: %s", err)
+			return err
+		}
+
+		peerID, err := randomHash()
+		if err != nil {
+			return err
+		}
+
+		logger.Infoln("The tracker will return one peer that doesn't have any pieces, the file is only available from the web seed")
+		peersResponse = createPeersResponse("127.0.0.1", peerPort)
+		startPeer(
+			PeerConnectionParams{
+				address:  fmt.Sprintf("127.0.0.1:%d", peerPort),
+				myPeerID: peerID,
+				infoHash: infoHash,
+				expectedReservedBytes: [][]byte{
+					{0, 0, 0, 0, 0, 0, 0, 0},
+					{0, 0, 0, 0, 0, 16, 0, 0},
+				},
+				bitfield: make([]byte, (fileLengthBytes/pieceLengthBytes+8)/8),
+				logger:   logger,
+			},
+			handlePeerWithoutPieces,
+		)
+	}
+
+	startTracker(TrackerParams{
+		trackerAddress:   trackerAddress,
+		peersResponse:    peersResponse,
+		expectedInfoHash: infoHash,
+		fileLengthBytes:  fileLengthBytes,
+		logger:           logger,
+	})
+
+	servedRanges := make(chan string, 1024)
+	startWebSeed(WebSeedParams{
+		address:      webSeedAddress,
+		filename:     filename,
+		content:      content,
+		servedRanges: servedRanges,
+		logger:       logger,
+	})
+
+	downloadedFilePath := path.Join(tempDir, filename)
+	logger.Infof("Running ./%s download -o %s %s", path.Base(executable.Path), downloadedFilePath, torrentFilePath)
+	result, err := executable.Run("download", "-o", downloadedFilePath, torrentFilePath)
+	if err != nil {
+		return err
+	}
+
+	if err = assertExitCode(result, 0); err != nil {
+		return err
+	}
+
+	if len(servedRanges) == 0 {
+		return errors.New("Expected the file to be downloaded from the web seed in the torrent's url-list, but no range requests were received")
+	}
+
+	logger.Successf("✓ Received %d range requests on the web seed.", len(servedRanges))
+
+	if err = assertFileSize(downloadedFilePath, int64(fileLengthBytes)); err != nil {
+		return err
+	}
+
+	if err = assertFileSHA1(downloadedFilePath, fmt.Sprintf("%x", sha1.Sum(content))); err != nil {
+		return err
+	}
+
+	logger.Successln("✓ File SHA-1 is correct.")
+
+	return nil
+}
+
+func handlePeerWithoutPieces(conn net.Conn, params PeerConnectionParams) error {
+	defer conn.Close()
+
+	if err := receiveAndSendHandshake(conn, params); err != nil {
+		return err
+	}
+
+	if err := sendBitfieldMessage(conn, params.bitfield, params.logger); err != nil {
+		return err
+	}
+
+	// Never unchoke, keep reading until the other side gives up
+	io.Copy(io.Discard, conn)
+	return nil
+}
@@ -1,15 +1,21 @@
 package internal
 
 import (
-	"os"
+	"fmt"
+	"path/filepath"
 	"regexp"
+	"sync"
 	"testing"
 
+	"github.com/codecrafters-io/tester-utils/executable"
+	"github.com/codecrafters-io/tester-utils/logger"
+	"github.com/codecrafters-io/tester-utils/random"
+	"github.com/codecrafters-io/tester-utils/test_runner"
 	tester_utils_testing "github.com/codecrafters-io/tester-utils/testing"
 )
 
 func TestStages(t *testing.T) {
-	os.Setenv("CODECRAFTERS_RANDOM_SEED", "1234567890")
+	t.Setenv("CODECRAFTERS_RANDOM_SEED", "1234567890")
 
 	testCases := map[string]tester_utils_testing.TesterOutputTestCase{
 		"bencoded_string_failure": {
@@ -33,6 +39,13 @@ func TestStages(t *testing.T) {
 			StdoutFixturePath:   "./test_helpers/fixtures/handshake/with_peer_check",
 			NormalizeOutputFunc: normalizeTesterOutput,
 		},
+		"local_stages": {
+			StageSlugs:          []string{"bm7", "bb8", "be9", "bf3", "fr4"},
+			CodePath:            "./test_helpers/scenarios/local_stages",
+			ExpectedExitCode:    0,
+			StdoutFixturePath:   "./test_helpers/fixtures/local_stages",
+			NormalizeOutputFunc: normalizeTesterOutput,
+		},
 		"pass_all": {
 			UntilStageSlug:      "dv7",
 			CodePath:            "./test_helpers/scenarios/pass_all",
@@ -42,7 +55,24 @@ func TestStages(t *testing.T) {
 		},
 	}
 
-	tester_utils_testing.TestTesterOutput(t, testerDefinition, testCases)
+	var leaksMu sync.Mutex
+	var leaks []string
+	defer func(original func(string, error, *logger.Logger)) { reportServerLeak = original }(reportServerLeak)
+	reportServerLeak = func(slug string, err error, logger *logger.Logger) {
+		leaksMu.Lock()
+		defer leaksMu.Unlock()
+		leaks = append(leaks, fmt.Sprintf("%s: %s", slug, err))
+	}
+
+	definition := testerDefinition
+	definition.TestCases = withTestCaseContexts(testerDefinition.TestCases, map[string]string{}, nil, nil)
+	tester_utils_testing.TestTesterOutput(t, definition, testCases)
+
+	leaksMu.Lock()
+	defer leaksMu.Unlock()
+	for _, leak := range leaks {
+		t.Errorf("Servers leaked after stage %s", leak)
+	}
 }
 
 func normalizeTesterOutput(testerOutput []byte) []byte {
@@ -59,3 +89,41 @@ func normalizeTesterOutput(testerOutput []byte) []byte {
 
 	return testerOutput
 }
+
+// A stage run twice in one process must not see the channels, ports or servers of the earlier run
+func TestStageRunsTwiceInOneProcess(t *testing.T) {
+	t.Setenv("CODECRAFTERS_RANDOM_SEED", "1234567890")
+	random.Init()
+
+	var leaksMu sync.Mutex
+	var leaks []string
+	defer func(original func(string, error, *logger.Logger)) { reportServerLeak = original }(reportServerLeak)
+	reportServerLeak = func(slug string, err error, logger *logger.Logger) {
+		leaksMu.Lock()
+		defer leaksMu.Unlock()
+		leaks = append(leaks, fmt.Sprintf("%s: %s", slug, err))
+	}
+
+	var steps []test_runner.TestRunnerStep
+	for _, testCase := range withTestCaseContexts(testerDefinition.TestCases, map[string]string{}, nil, nil) {
+		if testCase.Slug == "xi4" {
+			steps = append(steps,
+				test_runner.TestRunnerStep{TestCase: testCase, TesterLogPrefix: "xi4-1", Title: "first run"},
+				test_runner.TestRunnerStep{TestCase: testCase, TesterLogPrefix: "xi4-2", Title: "second run"})
+		}
+	}
+
+	codePath, err := filepath.Abs("./test_helpers/scenarios/pass_all/your_bittorrent.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !test_runner.NewQuietTestRunner(steps).Run(false, executable.NewExecutable(codePath)) {
+		t.Error("expected both runs of the stage to pass")
+	}
+
+	leaksMu.Lock()
+	defer leaksMu.Unlock()
+	for _, leak := range leaks {
+		t.Errorf("Servers leaked after stage %s", leak)
+	}
+}
@@ -0,0 +1,221 @@
+// State shared by everything a single test case starts, looked up through the test case's logger
+package internal
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	logger "github.com/codecrafters-io/tester-utils/logger"
+	"github.com/codecrafters-io/tester-utils/test_case_harness"
+	"github.com/codecrafters-io/tester-utils/tester_definition"
+)
+
+// pcapDirectoryEnvVar names the directory that receives a pcapng capture of every test case
+const pcapDirectoryEnvVar = "CODECRAFTERS_PCAP_DIRECTORY"
+
+type testCaseContext struct {
+	slug string
+	// ctx is cancelled when the test case ends, which stops its trackers and peers
+	ctx          context.Context
+	cancel       context.CancelFunc
+	servers      *serverLifecycle
+	capture      *pcapWriter
+	report       *stageReport
+	faultProfile string
+	startedAt    time.Time
+	counters     metricsCounters
+
+	mu               sync.Mutex
+	commands         []commandReport
+	trackerExchanges []trackerExchange
+	transcripts      []*transcriptConn
+}
+
+var (
+	testCaseContextsMu sync.Mutex
+	testCaseContexts   = make(map[*logger.Logger]*testCaseContext)
+)
+
+// testCaseContextFor returns the context of the test case that owns the logger, or nil if it has none.
+// Trackers and peers only get the logger passed down, so it doubles as the test case's identity.
+func testCaseContextFor(logger *logger.Logger) *testCaseContext {
+	testCaseContextsMu.Lock()
+	defer testCaseContextsMu.Unlock()
+	return testCaseContexts[logger]
+}
+
+// contextFor returns a context that's cancelled when the test case that owns the logger ends. Without a test case
+// context, it's never cancelled.
+func contextFor(logger *logger.Logger) context.Context {
+	if testCase := testCaseContextFor(logger); testCase != nil {
+		return testCase.ctx
+	}
+	return context.Background()
+}
+
+// reportServerLeak is called when the servers of a test case didn't stop after it ended. The internal tests replace
+// it to fail on leaks.
+var reportServerLeak = func(slug string, err error, logger *logger.Logger) {
+	logger.Debugf("Servers of %s didn't stop: %s", slug, err)
+}
+
+// captureFor returns the packet capture of the test case that owns the logger, or nil if captures are disabled
+func captureFor(logger *logger.Logger) *pcapWriter {
+	if testCase := testCaseContextFor(logger); testCase != nil {
+		return testCase.capture
+	}
+	return nil
+}
+
+func newTestCaseContext(slug string, env map[string]string) *testCaseContext {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &testCaseContext{
+		slug:         slug,
+		ctx:          ctx,
+		cancel:       cancel,
+		servers:      newServerLifecycle(ctx),
+		faultProfile: env[faultProfileEnvVar],
+		startedAt:    time.Now(),
+	}
+}
+
+func (c *testCaseContext) metrics() stageMetrics {
+	return stageMetrics{
+		DurationSeconds: time.Since(c.startedAt).Seconds(),
+		BytesSent:       c.counters.bytesSent.Load(),
+		BytesReceived:   c.counters.bytesReceived.Load(),
+		Connections:     c.counters.connections.Load(),
+		Requests:        c.counters.requests.Load(),
+	}
+}
+
+func (c *testCaseContext) addCommand(command commandReport) {
+	c.mu.Lock()
+	c.commands = append(c.commands, command)
+	c.mu.Unlock()
+
+	if c.report != nil {
+		c.report.addCommand(command)
+	}
+}
+
+func (c *testCaseContext) addTrackerExchange(exchange trackerExchange) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.trackerExchanges = append(c.trackerExchanges, exchange)
+}
+
+func (c *testCaseContext) addTranscript(transcript *transcriptConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.transcripts = append(c.transcripts, transcript)
+}
+
+// protocolViolation returns the first message the client sent out of order on any of its peer connections
+func (c *testCaseContext) protocolViolation() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, transcript := range c.transcripts {
+		if err := transcript.protocolViolation(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withTestCaseContexts wraps every test function so that it runs with its own test case context. Stages are
+// added to the report if it isn't nil, and the metrics of passed stages to the metrics recorder.
+func withTestCaseContexts(testCases []tester_definition.TestCase, env map[string]string, report *testReport, metrics *metricsRecorder) []tester_definition.TestCase {
+	wrapped := make([]tester_definition.TestCase, len(testCases))
+	for i, original := range testCases {
+		testFunc := original.TestFunc
+		slug := original.Slug
+		timeout := original.CustomOrDefaultTimeout()
+
+		wrapped[i] = original
+		wrapped[i].TestFunc = func(harness *test_case_harness.TestCaseHarness) error {
+			testCase := newTestCaseContext(slug, env)
+			// Registered first, so the trackers and peers stop before anything else is torn down
+			harness.RegisterTeardownFunc(func() {
+				testCase.cancel()
+				if err := testCase.servers.stop(serverShutdownTimeout); err != nil {
+					reportServerLeak(slug, err, harness.Logger)
+				}
+			})
+			timeoutErr := fmt.Errorf("timed out, test exceeded %d seconds", int64(timeout.Seconds()))
+
+			if testCase.faultProfile != "" {
+				if _, err := faultProfileNamed(testCase.faultProfile); err != nil {
+					return fmt.Errorf("invalid %s: %v", faultProfileEnvVar, err)
+				}
+			}
+
+			if directory := env[pcapDirectoryEnvVar]; directory != "" {
+				capturePath := filepath.Join(directory, slug+".pcapng")
+				capture, err := newPCAPWriter(capturePath)
+				if err != nil {
+					return fmt.Errorf("error creating packet capture: %v", err)
+				}
+				testCase.capture = capture
+				harness.Logger.Debugf("Writing packet capture to %s", capturePath)
+				harness.RegisterTeardownFunc(func() { capture.Close() })
+			}
+
+			if report != nil {
+				testCase.report = report.startStage(slug)
+				// Runs after a timeout too, when the test function never returned
+				harness.RegisterTeardownFunc(func() { testCase.report.finish(timeoutErr) })
+			}
+
+			// Teardown functions run once the result is known, so a timed out test function hasn't set this
+			var result error = timeoutErr
+			var resultMu sync.Mutex
+			if directory := env[failureBundleDirectoryEnvVar]; directory != "" {
+				harness.RegisterTeardownFunc(func() {
+					resultMu.Lock()
+					err := result
+					resultMu.Unlock()
+					if err != nil {
+						writeFailureBundle(directory, testCase, err, env, harness.Logger)
+					}
+				})
+			}
+
+			testCaseContextsMu.Lock()
+			testCaseContexts[harness.Logger] = testCase
+			testCaseContextsMu.Unlock()
+			harness.RegisterTeardownFunc(func() {
+				testCaseContextsMu.Lock()
+				delete(testCaseContexts, harness.Logger)
+				testCaseContextsMu.Unlock()
+			})
+
+			err := testFunc(harness)
+			if err == nil {
+				// A stage with the right output still fails if the client sent a peer messages out of order
+				err = testCase.protocolViolation()
+			}
+			if testCase.report != nil {
+				testCase.report.finish(err)
+			}
+			if err == nil && metrics != nil {
+				stageMetrics := testCase.metrics()
+				regressions := metrics.record(slug, stageMetrics)
+				for _, regression := range regressions {
+					harness.Logger.Errorf("WARNING: Performance regression, %s compared to the baseline", regression)
+				}
+				if testCase.report != nil {
+					testCase.report.setMetrics(stageMetrics, regressions)
+				}
+			}
+			resultMu.Lock()
+			result = err
+			resultMu.Unlock()
+			return err
+		}
+	}
+	return wrapped
+}
@@ -0,0 +1,30 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/codecrafters-io/tester-utils/logger"
+)
+
+// registerTestCaseContext registers a test case context for a new logger until the test ends
+func registerTestCaseContext(t *testing.T) (*testCaseContext, *logger.Logger) {
+	logger := logger.GetQuietLogger("")
+	context := newTestCaseContext(t.Name(), map[string]string{})
+
+	testCaseContextsMu.Lock()
+	testCaseContexts[logger] = context
+	testCaseContextsMu.Unlock()
+	t.Cleanup(func() {
+		context.cancel()
+		testCaseContextsMu.Lock()
+		delete(testCaseContexts, logger)
+		testCaseContextsMu.Unlock()
+	})
+	return context, logger
+}
+
+func TestContextForWithoutTestCase(t *testing.T) {
+	if err := contextFor(logger.GetQuietLogger("")).Err(); err != nil {
+		t.Errorf("expected a context that's never cancelled, got %v", err)
+	}
+}
@@ -0,0 +1,526 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: your_bittorrent.sh <command> <args>")
+		os.Exit(1)
+	}
+
+	var err error
+	switch command := os.Args[1]; command {
+	case "decode":
+		err = decodeCommand(os.Args[2])
+	case "encode":
+		err = encodeCommand(os.Args[2])
+	case "download":
+		if len(os.Args) != 5 || os.Args[2] != "-o" {
+			err = errors.New("usage: download -o <output> <torrent>")
+			break
+		}
+		err = downloadCommand(os.Args[3], os.Args[4])
+	default:
+		err = fmt.Errorf("unknown command: %s", command)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// decodeCommand decodes a bencoded value passed as the argument, read from a file, or read from stdin with "-"
+func decodeCommand(arg string) error {
+	data := []byte(arg)
+	if arg == "-" {
+		stdin, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		data = stdin
+	} else if info, err := os.Stat(arg); err == nil && info.Mode().IsRegular() {
+		contents, err := os.ReadFile(arg)
+		if err != nil {
+			return err
+		}
+		data = contents
+	}
+
+	value, err := decodeBencode(data)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(toJSON(value))
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(encoded))
+	return nil
+}
+
+// toJSON prints strings that aren't valid UTF-8 as hex
+func toJSON(value interface{}) interface{} {
+	switch value := value.(type) {
+	case string:
+		if !utf8.ValidString(value) {
+			return hex.EncodeToString([]byte(value))
+		}
+		return value
+	case []interface{}:
+		converted := make([]interface{}, len(value))
+		for i, element := range value {
+			converted[i] = toJSON(element)
+		}
+		return converted
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, element := range value {
+			converted[key] = toJSON(element)
+		}
+		return converted
+	default:
+		return value
+	}
+}
+
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+func decodeBencode(data []byte) (interface{}, error) {
+	d := &decoder{data: data}
+	value, err := d.value()
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("trailing data at offset %d", d.pos)
+	}
+	return value, nil
+}
+
+func (d *decoder) value() (interface{}, error) {
+	if d.pos >= len(d.data) {
+		return nil, errors.New("unexpected end of input")
+	}
+
+	switch c := d.data[d.pos]; {
+	case c == 'i':
+		return d.integer()
+	case c == 'l':
+		d.pos++
+		list := []interface{}{}
+		for {
+			if d.pos >= len(d.data) {
+				return nil, errors.New("list isn't terminated")
+			}
+			if d.data[d.pos] == 'e' {
+				d.pos++
+				return list, nil
+			}
+			element, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, element)
+		}
+	case c == 'd':
+		d.pos++
+		dict := map[string]interface{}{}
+		previousKey := ""
+		for i := 0; ; i++ {
+			if d.pos >= len(d.data) {
+				return nil, errors.New("dictionary isn't terminated")
+			}
+			if d.data[d.pos] == 'e' {
+				d.pos++
+				return dict, nil
+			}
+			if d.data[d.pos] < '0' || d.data[d.pos] > '9' {
+				return nil, fmt.Errorf("dictionary key at offset %d isn't a string", d.pos)
+			}
+			key, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			if i > 0 && key <= previousKey {
+				return nil, fmt.Errorf("dictionary key %q isn't sorted or is repeated", key)
+			}
+			previousKey = key
+			element, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			dict[key] = element
+		}
+	case c >= '0' && c <= '9':
+		return d.string()
+	default:
+		return nil, fmt.Errorf("unexpected %q at offset %d", c, d.pos)
+	}
+}
+
+func (d *decoder) integer() (int64, error) {
+	end := bytes.IndexByte(d.data[d.pos:], 'e')
+	if end < 0 {
+		return 0, errors.New("integer isn't terminated")
+	}
+	digits := string(d.data[d.pos+1 : d.pos+end])
+	unsigned := digits
+	if len(unsigned) > 0 && unsigned[0] == '-' {
+		unsigned = unsigned[1:]
+	}
+	if unsigned == "" || (unsigned[0] == '0' && len(digits) > 1) {
+		return 0, fmt.Errorf("invalid integer %q", digits)
+	}
+	for _, c := range unsigned {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("invalid integer %q", digits)
+		}
+	}
+	value, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	d.pos += end + 1
+	return value, nil
+}
+
+func (d *decoder) string() (string, error) {
+	colon := bytes.IndexByte(d.data[d.pos:], ':')
+	if colon < 0 {
+		return "", errors.New("string length isn't terminated")
+	}
+	length, err := strconv.Atoi(string(d.data[d.pos : d.pos+colon]))
+	if err != nil || length < 0 {
+		return "", fmt.Errorf("invalid string length at offset %d", d.pos)
+	}
+	start := d.pos + colon + 1
+	if length > len(d.data)-start {
+		return "", fmt.Errorf("string length %d is longer than the remaining input", length)
+	}
+	d.pos = start + length
+	return string(d.data[start:d.pos]), nil
+}
+
+// encodeCommand prints the canonical bencoding of a JSON value
+func encodeCommand(arg string) error {
+	jsonDecoder := json.NewDecoder(bytes.NewReader([]byte(arg)))
+	jsonDecoder.UseNumber()
+	var value interface{}
+	if err := jsonDecoder.Decode(&value); err != nil {
+		return err
+	}
+
+	var buffer bytes.Buffer
+	if err := encodeBencode(&buffer, value); err != nil {
+		return err
+	}
+	fmt.Println(buffer.String())
+	return nil
+}
+
+func encodeBencode(buffer *bytes.Buffer, value interface{}) error {
+	switch value := value.(type) {
+	case string:
+		fmt.Fprintf(buffer, "%d:%s", len(value), value)
+	case json.Number:
+		integer, err := value.Int64()
+		if err != nil {
+			return fmt.Errorf("%s isn't an integer", value)
+		}
+		fmt.Fprintf(buffer, "i%de", integer)
+	case []interface{}:
+		buffer.WriteByte('l')
+		for _, element := range value {
+			if err := encodeBencode(buffer, element); err != nil {
+				return err
+			}
+		}
+		buffer.WriteByte('e')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buffer.WriteByte('d')
+		for _, key := range keys {
+			fmt.Fprintf(buffer, "%d:%s", len(key), key)
+			if err := encodeBencode(buffer, value[key]); err != nil {
+				return err
+			}
+		}
+		buffer.WriteByte('e')
+	default:
+		return fmt.Errorf("can't encode %v", value)
+	}
+	return nil
+}
+
+type torrent struct {
+	announce    string
+	infoHash    [20]byte
+	length      int
+	pieceLength int
+	pieceHashes []string
+}
+
+func openTorrent(path string) (*torrent, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := decodeBencode(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	metainfo, ok := decoded.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("torrent file isn't a dictionary")
+	}
+	info, ok := metainfo["info"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("torrent file has no info dictionary")
+	}
+
+	// Re-encoding is canonical, so it matches the bytes in the file
+	var encodedInfo bytes.Buffer
+	if err := encodeBencode(&encodedInfo, toJSONNumbers(info)); err != nil {
+		return nil, err
+	}
+
+	pieces := info["pieces"].(string)
+	t := &torrent{
+		announce:    metainfo["announce"].(string),
+		infoHash:    sha1.Sum(encodedInfo.Bytes()),
+		length:      int(info["length"].(int64)),
+		pieceLength: int(info["piece length"].(int64)),
+	}
+	for i := 0; i < len(pieces); i += 20 {
+		t.pieceHashes = append(t.pieceHashes, pieces[i:i+20])
+	}
+	return t, nil
+}
+
+// toJSONNumbers converts decoded integers to the type encodeBencode takes
+func toJSONNumbers(value interface{}) interface{} {
+	switch value := value.(type) {
+	case int64:
+		return json.Number(strconv.FormatInt(value, 10))
+	case []interface{}:
+		converted := make([]interface{}, len(value))
+		for i, element := range value {
+			converted[i] = toJSONNumbers(element)
+		}
+		return converted
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, element := range value {
+			converted[key] = toJSONNumbers(element)
+		}
+		return converted
+	default:
+		return value
+	}
+}
+
+func requestPeers(t *torrent, peerID [20]byte) ([]string, error) {
+	query := url.Values{}
+	query.Set("info_hash", string(t.infoHash[:]))
+	query.Set("peer_id", string(peerID[:]))
+	query.Set("port", "6881")
+	query.Set("uploaded", "0")
+	query.Set("downloaded", "0")
+	query.Set("left", strconv.Itoa(t.length))
+	query.Set("compact", "1")
+
+	response, err := http.Get(t.announce + "?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := decodeBencode(body)
+	if err != nil {
+		return nil, err
+	}
+	dict, ok := decoded.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("tracker response isn't a dictionary")
+	}
+	compactPeers, ok := dict["peers"].(string)
+	if !ok {
+		return nil, fmt.Errorf("tracker response has no peers: %s", body)
+	}
+
+	var peers []string
+	for i := 0; i+6 <= len(compactPeers); i += 6 {
+		ip := net.IP([]byte(compactPeers[i : i+4]))
+		port := binary.BigEndian.Uint16([]byte(compactPeers[i+4 : i+6]))
+		peers = append(peers, net.JoinHostPort(ip.String(), strconv.Itoa(int(port))))
+	}
+	return peers, nil
+}
+
+type message struct {
+	id      byte
+	payload []byte
+}
+
+// readMessage reads a whole message by its length prefix, however the bytes are split across reads
+func readMessage(conn io.Reader) (*message, error) {
+	lengthPrefix := make([]byte, 4)
+	if _, err := io.ReadFull(conn, lengthPrefix); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(lengthPrefix)
+	if length == 0 {
+		return nil, nil
+	}
+	buffer := make([]byte, length)
+	if _, err := io.ReadFull(conn, buffer); err != nil {
+		return nil, err
+	}
+	return &message{id: buffer[0], payload: buffer[1:]}, nil
+}
+
+func writeMessage(conn io.Writer, id byte, payload []byte) error {
+	buffer := make([]byte, 5+len(payload))
+	binary.BigEndian.PutUint32(buffer, uint32(1+len(payload)))
+	buffer[4] = id
+	copy(buffer[5:], payload)
+	_, err := conn.Write(buffer)
+	return err
+}
+
+// waitForMessage skips keep-alives and other messages until one with the given id arrives
+func waitForMessage(conn io.Reader, id byte) (*message, error) {
+	for {
+		msg, err := readMessage(conn)
+		if err != nil {
+			return nil, err
+		}
+		if msg != nil && msg.id == id {
+			return msg, nil
+		}
+	}
+}
+
+const blockSize = 16 * 1024
+
+func downloadPiece(conn net.Conn, t *torrent, index int) ([]byte, error) {
+	length := t.pieceLength
+	if index == len(t.pieceHashes)-1 {
+		length = t.length - index*t.pieceLength
+	}
+
+	// Request every block up front, then collect the replies
+	blocks := 0
+	for begin := 0; begin < length; begin += blockSize {
+		request := make([]byte, 12)
+		binary.BigEndian.PutUint32(request[0:4], uint32(index))
+		binary.BigEndian.PutUint32(request[4:8], uint32(begin))
+		binary.BigEndian.PutUint32(request[8:12], uint32(min(blockSize, length-begin)))
+		if err := writeMessage(conn, 6, request); err != nil {
+			return nil, err
+		}
+		blocks++
+	}
+
+	piece := make([]byte, length)
+	for range blocks {
+		msg, err := waitForMessage(conn, 7)
+		if err != nil {
+			return nil, err
+		}
+		begin := int(binary.BigEndian.Uint32(msg.payload[4:8]))
+		copy(piece[begin:], msg.payload[8:])
+	}
+
+	if sha1.Sum(piece) != [20]byte([]byte(t.pieceHashes[index])) {
+		return nil, fmt.Errorf("piece %d failed the hash check", index)
+	}
+	return piece, nil
+}
+
+func downloadCommand(outputPath string, torrentPath string) error {
+	t, err := openTorrent(torrentPath)
+	if err != nil {
+		return err
+	}
+
+	var peerID [20]byte
+	if _, err := rand.Read(peerID[:]); err != nil {
+		return err
+	}
+	peers, err := requestPeers(t, peerID)
+	if err != nil {
+		return err
+	}
+	if len(peers) == 0 {
+		return errors.New("the tracker returned no peers")
+	}
+
+	conn, err := net.Dial("tcp", peers[0])
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	handshake := append([]byte{19}, "BitTorrent protocol"...)
+	handshake = append(handshake, make([]byte, 8)...)
+	handshake = append(handshake, t.infoHash[:]...)
+	handshake = append(handshake, peerID[:]...)
+	if _, err := conn.Write(handshake); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 68)); err != nil {
+		return err
+	}
+
+	if _, err := waitForMessage(conn, 5); err != nil {
+		return err
+	}
+	if err := writeMessage(conn, 2, nil); err != nil {
+		return err
+	}
+	if _, err := waitForMessage(conn, 1); err != nil {
+		return err
+	}
+
+	var file bytes.Buffer
+	for index := range t.pieceHashes {
+		piece, err := downloadPiece(conn, t, index)
+		if err != nil {
+			return err
+		}
+		file.Write(piece)
+	}
+	return os.WriteFile(outputPath, file.Bytes(), 0644)
+}
@@ -0,0 +1,3 @@
+module github.com/codecrafters-io/bittorrent-local-stages
+
+go 1.24
@@ -22,12 +22,12 @@ var testerDefinition = tester_definition.TesterDefinition{
 		{
 			Slug:     "ah1",
 			TestFunc: testBencodeList,
-			Timeout:  15 * time.Second,
+			Timeout:  30 * time.Second,
 		},
 		{
 			Slug:     "mn6",
 			TestFunc: testBencodeDict,
-			Timeout:  15 * time.Second,
+			Timeout:  30 * time.Second,
 		},
 		{
 			Slug:     "ow9",
@@ -91,5 +91,69 @@ var testerDefinition = tester_definition.TesterDefinition{
 			TestFunc: testMagnetDownloadFile,
 			Timeout:  90 * time.Second,
 		},
+		{
+			Slug:     "ey3",
+			TestFunc: testEncryptedHandshake,
+		},
+		{
+			Slug:     "ut5",
+			TestFunc: testUTPHandshake,
+		},
+		{
+			Slug:     "ws4",
+			TestFunc: testWebSeedDownload,
+			Timeout:  20 * time.Second,
+		},
+		{
+			Slug:     "vh2",
+			TestFunc: testV2InfoHash,
+		},
+		{
+			Slug:     "vt3",
+			TestFunc: testV2FileTree,
+		},
+		{
+			Slug:     "vd4",
+			TestFunc: testV2DownloadFile,
+			Timeout:  20 * time.Second,
+		},
+		{
+			Slug:     "pt6",
+			TestFunc: testPrivateTorrent,
+		},
+		{
+			Slug:     "bm7",
+			TestFunc: testBencodeMalformed,
+		},
+		{
+			Slug:     "bb8",
+			TestFunc: testBencodeBinaryStrings,
+		},
+		{
+			Slug:     "be9",
+			TestFunc: testBencodeEncode,
+		},
+		{
+			Slug:     "bs1",
+			TestFunc: testBencodeStress,
+			Timeout:  30 * time.Second,
+		},
+		{
+			Slug:     "bf3",
+			TestFunc: testBencodeDecodeFile,
+		},
+		{
+			Slug:     "ik5",
+			TestFunc: testExtraInfoKeys,
+		},
+		{
+			Slug:     "if6",
+			TestFunc: testInfoFields,
+		},
+		{
+			Slug:     "fr4",
+			TestFunc: testFragmentedMessages,
+			Timeout:  20 * time.Second,
+		},
 	},
 }
@@ -0,0 +1,295 @@
+// Records the frames exchanged with the user's client so failures can show what happened on the wire
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	logger "github.com/codecrafters-io/tester-utils/logger"
+	"github.com/jackpal/bencode-go"
+)
+
+// transcriptFramesOnError is the number of frames printed when a connection handler fails
+const transcriptFramesOnError = 20
+
+// Messages longer than this aren't BitTorrent messages, the stream is probably encrypted or garbled
+const maxTranscriptMessageLength = 1 << 20
+
+type transcriptDirection string
+
+const (
+	directionReceived transcriptDirection = "client → peer"
+	directionSent     transcriptDirection = "peer → client"
+)
+
+type transcriptFrame struct {
+	time      time.Duration
+	direction transcriptDirection
+	kind      string
+	summary   string
+}
+
+// transcriptStream splits the bytes sent in one direction into frames
+type transcriptStream struct {
+	direction     transcriptDirection
+	buffer        []byte
+	seenHandshake bool
+	undecodable   bool
+}
+
+// transcriptConn records every frame read from and written to the wrapped connection
+type transcriptConn struct {
+	net.Conn
+	start    time.Time
+	mu       sync.Mutex
+	frames   []transcriptFrame
+	received transcriptStream
+	sent     transcriptStream
+	protocol *protocolStateMachine
+	// counters, if set, count the piece requests sent by the client
+	counters *metricsCounters
+}
+
+func newTranscriptConn(conn net.Conn) *transcriptConn {
+	return &transcriptConn{
+		Conn:     conn,
+		start:    time.Now(),
+		received: transcriptStream{direction: directionReceived},
+		sent:     transcriptStream{direction: directionSent},
+		protocol: newProtocolStateMachine(),
+	}
+}
+
+func (c *transcriptConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.record(&c.received, b[:n])
+	}
+	return n, err
+}
+
+func (c *transcriptConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.record(&c.sent, b[:n])
+	}
+	return n, err
+}
+
+func (c *transcriptConn) record(stream *transcriptStream, b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elapsed := time.Since(c.start)
+	if stream.undecodable {
+		c.frames = append(c.frames, transcriptFrame{elapsed, stream.direction, "raw", fmt.Sprintf("%d bytes", len(b))})
+		return
+	}
+
+	stream.buffer = append(stream.buffer, b...)
+	for {
+		kind, summary, msg, length := parseTranscriptFrame(stream)
+		if length == 0 {
+			return
+		}
+		if length < 0 {
+			stream.undecodable = true
+			c.frames = append(c.frames, transcriptFrame{elapsed, stream.direction, "raw", fmt.Sprintf("%d bytes, not a BitTorrent message: %x...", len(stream.buffer), stream.buffer[:min(len(stream.buffer), 8)])})
+			stream.buffer = nil
+			return
+		}
+		c.frames = append(c.frames, transcriptFrame{elapsed, stream.direction, kind, summary})
+		c.protocol.observe(stream.direction, protocolEvent(kind, msg))
+		if c.counters != nil && stream.direction == directionReceived && msg != nil && msg.ID == MsgRequest {
+			c.counters.requests.Add(1)
+		}
+		stream.buffer = stream.buffer[length:]
+	}
+}
+
+// parseTranscriptFrame returns the length of the first complete frame in the buffer, 0 if more data is
+// needed, or -1 if the data isn't a BitTorrent frame. The message is nil for handshakes and keep-alives.
+func parseTranscriptFrame(stream *transcriptStream) (string, string, *Message, int) {
+	buffer := stream.buffer
+
+	if !stream.seenHandshake {
+		if len(buffer) < 1 {
+			return "", "", nil, 0
+		}
+		if buffer[0] != byte(len(ProtocolName)) {
+			return "", "", nil, -1
+		}
+		if len(buffer) < 68 {
+			return "", "", nil, 0
+		}
+		if string(buffer[1:20]) != ProtocolName {
+			return "", "", nil, -1
+		}
+		stream.seenHandshake = true
+		return "handshake", fmt.Sprintf("reserved: %v, infohash: %x, peer_id: %x", buffer[20:28], buffer[28:48], buffer[48:68]), nil, 68
+	}
+
+	if len(buffer) < 4 {
+		return "", "", nil, 0
+	}
+	length := int(binary.BigEndian.Uint32(buffer[0:4]))
+	if length > maxTranscriptMessageLength {
+		return "", "", nil, -1
+	}
+	if len(buffer) < 4+length {
+		return "", "", nil, 0
+	}
+	if length == 0 {
+		return "keep-alive", "", nil, 4
+	}
+
+	msg := Message{ID: messageID(buffer[4]), Payload: buffer[5 : 4+length]}
+	return msg.ID.String(), summarizeMessagePayload(&msg), &msg, 4 + length
+}
+
+func summarizeMessagePayload(msg *Message) string {
+	payload := msg.Payload
+
+	switch msg.ID {
+	case MsgHave:
+		if len(payload) == 4 {
+			return fmt.Sprintf("index: %d", binary.BigEndian.Uint32(payload))
+		}
+	case MsgRequest, MsgCancel:
+		if len(payload) == 12 {
+			return fmt.Sprintf("index: %d, begin: %d, length: %d", binary.BigEndian.Uint32(payload[0:4]), binary.BigEndian.Uint32(payload[4:8]), binary.BigEndian.Uint32(payload[8:12]))
+		}
+	case MsgPiece:
+		if len(payload) >= 8 {
+			return fmt.Sprintf("index: %d, begin: %d, block: %d bytes", binary.BigEndian.Uint32(payload[0:4]), binary.BigEndian.Uint32(payload[4:8]), len(payload)-8)
+		}
+	case MsgBitfield:
+		return fmt.Sprintf("%x", payload)
+	case MsgPort:
+		if len(payload) == 2 {
+			return fmt.Sprintf("port: %d", binary.BigEndian.Uint16(payload))
+		}
+	case MsgExtended:
+		if len(payload) >= 1 {
+			return fmt.Sprintf("extension id: %d, %s", payload[0], summarizeBencodedPayload(payload[1:]))
+		}
+	}
+
+	if len(payload) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d bytes", len(payload))
+}
+
+// summarizeBencodedPayload renders a bencoded dictionary followed by optional raw data, like metadata pieces
+func summarizeBencodedPayload(payload []byte) string {
+	reader := bytes.NewReader(payload)
+	decoded, err := bencode.Decode(reader)
+	if err != nil {
+		return fmt.Sprintf("invalid bencode: %q", payload[:min(len(payload), 40)])
+	}
+
+	summary := fmt.Sprintf("%v", decoded)
+	if reader.Len() > 0 {
+		summary += fmt.Sprintf(" + %d bytes", reader.Len())
+	}
+	return summary
+}
+
+// protocolViolation returns the first message the client sent out of order, if any
+func (c *transcriptConn) protocolViolation() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.protocol.violation
+}
+
+// handleRecordedConnection runs the handler on a recorded connection, and prints the last frames if it fails or the
+// client sent a message out of order. The connection is closed when the test case ends. For peers that require
+// encryption, the MSE handshake runs first and the decrypted stream is recorded.
+func handleRecordedConnection(rawConn net.Conn, p PeerConnectionParams, handler ConnectionHandler) {
+	ctx := contextFor(p.logger)
+	defer context.AfterFunc(ctx, func() { rawConn.Close() })()
+
+	conn := rawConn
+	if p.requiresEncryption {
+		encryptedConn, err := acceptEncryptedConnection(rawConn, p.infoHash, p.logger)
+		if err != nil {
+			rawConn.Close()
+			return
+		}
+		p.logger.Debugln("MSE handshake complete, continuing over RC4 encrypted stream")
+		conn = encryptedConn
+	}
+
+	recorder := newTranscriptConn(conn)
+	if testCase := testCaseContextFor(p.logger); testCase != nil {
+		recorder.counters = &testCase.counters
+		testCase.addTranscript(recorder)
+	}
+
+	err := handler(recorder, p)
+	if err == nil {
+		// Handlers that don't read every message through readMessage haven't checked the order yet
+		if err = recorder.protocolViolation(); err != nil {
+			p.logger.Errorf("%s", err)
+		}
+	}
+	if err != nil && ctx.Err() == nil {
+		recorder.logLastFrames(p.logger, transcriptFramesOnError)
+	}
+}
+
+// logLastFrames prints the last n frames as a table
+func (c *transcriptConn) logLastFrames(logger *logger.Logger, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	frames := c.frames[max(0, len(c.frames)-n):]
+	if len(frames) == 0 {
+		logger.Infoln("No data was exchanged on this connection")
+		return
+	}
+
+	if len(frames) < len(c.frames) {
+		logger.Infof("Last %d of %d frames exchanged on this connection:", len(frames), len(c.frames))
+	} else {
+		logger.Infof("Frames exchanged on this connection:")
+	}
+	for _, line := range formatTranscriptFrames(frames) {
+		logger.Infoln(line)
+	}
+}
+
+// lines renders every frame of the connection as a table
+func (c *transcriptConn) lines() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return formatTranscriptFrames(c.frames)
+}
+
+func formatTranscriptFrames(frames []transcriptFrame) []string {
+	lines := []string{fmt.Sprintf("%10s  %-13s  %-14s  %s", "time", "direction", "frame", "details")}
+	for _, frame := range frames {
+		lines = append(lines, fmt.Sprintf("%10s  %-13s  %-14s  %s", formatTranscriptTime(frame.time), frame.direction, frame.kind, truncateTranscriptSummary(frame.summary)))
+	}
+	return lines
+}
+
+func formatTranscriptTime(d time.Duration) string {
+	return fmt.Sprintf("+%.1fms", float64(d.Microseconds())/1000)
+}
+
+func truncateTranscriptSummary(summary string) string {
+	const maxLength = 120
+	summary = strings.ReplaceAll(summary, "\n", " ")
+	if len(summary) > maxLength {
+		return summary[:maxLength] + "..."
+	}
+	return summary
+}
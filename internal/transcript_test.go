@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"io"
+	"testing"
+)
+
+func TestTranscriptConnDecodesFrames(t *testing.T) {
+	client, server := tcpConnPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	recorder := newTranscriptConn(server)
+
+	go func() {
+		sendHandshake(client, [8]byte{0, 0, 0, 0, 0, 16, 0, 0}, [20]byte{1}, [20]byte{2})
+		// Split a message across writes to check that frames are reassembled
+		serialized := (&Message{ID: MsgRequest, Payload: []byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 64, 0}}).Serialize()
+		client.Write(serialized[:3])
+		client.Write(serialized[3:])
+		client.Write((&Message{ID: MsgExtended, Payload: append([]byte{0}, "d1:md11:ut_metadatai3eee"...)}).Serialize())
+		client.Close()
+	}()
+
+	if _, err := io.Copy(io.Discard, recorder); err != nil {
+		t.Fatal(err)
+	}
+	recorder.Write((&Message{ID: MsgUnchoke}).Serialize())
+
+	expected := []struct {
+		direction transcriptDirection
+		kind      string
+		summary   string
+	}{
+		{directionReceived, "handshake", "reserved: [0 0 0 0 0 16 0 0], infohash: 0100000000000000000000000000000000000000, peer_id: 0200000000000000000000000000000000000000"},
+		{directionReceived, "request", "index: 1, begin: 0, length: 16384"},
+		{directionReceived, "extended", "extension id: 0, map[m:map[ut_metadata:3]]"},
+		{directionSent, "raw", "5 bytes, not a BitTorrent message: 0000000101..."},
+	}
+	if len(recorder.frames) != len(expected) {
+		t.Fatalf("expected %d frames, got %d: %+v", len(expected), len(recorder.frames), recorder.frames)
+	}
+	for i, frame := range recorder.frames {
+		if frame.direction != expected[i].direction || frame.kind != expected[i].kind || frame.summary != expected[i].summary {
+			t.Errorf("frame %d: expected %+v, got %+v", i, expected[i], frame)
+		}
+	}
+}
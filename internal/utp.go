@@ -0,0 +1,712 @@
+// Minimal uTP (BEP 29) transport used by emulated peers that are only reachable over UDP
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	logger "github.com/codecrafters-io/tester-utils/logger"
+)
+
+type utpPacketType uint8
+
+const (
+	utpTypeData  utpPacketType = 0
+	utpTypeFin   utpPacketType = 1
+	utpTypeState utpPacketType = 2
+	utpTypeReset utpPacketType = 3
+	utpTypeSyn   utpPacketType = 4
+
+	utpVersion          = 1
+	utpHeaderLength     = 20
+	utpMaxPayloadLength = 1200
+	utpReceiveWindow    = 1 << 20
+	utpTraceLength      = 32
+
+	// LEDBAT parameters from BEP 29
+	utpTargetDelayMicroseconds = 100000
+	utpMaxWindowIncrease       = 3000
+
+	utpMinTimeout   = 500 * time.Millisecond
+	utpStallTimeout = 3 * time.Second
+	utpCloseTimeout = 2 * time.Second
+)
+
+func (t utpPacketType) String() string {
+	switch t {
+	case utpTypeData:
+		return "ST_DATA"
+	case utpTypeFin:
+		return "ST_FIN"
+	case utpTypeState:
+		return "ST_STATE"
+	case utpTypeReset:
+		return "ST_RESET"
+	case utpTypeSyn:
+		return "ST_SYN"
+	default:
+		return fmt.Sprintf("UNKNOWN(%d)", uint8(t))
+	}
+}
+
+type utpPacket struct {
+	Type                uint8
+	Version             uint8
+	ConnectionID        uint16
+	Timestamp           uint32
+	TimestampDifference uint32
+	WindowSize          uint32
+	SeqNr               uint16
+	AckNr               uint16
+	Payload             []byte
+}
+
+func (p *utpPacket) packetType() utpPacketType {
+	return utpPacketType(p.Type)
+}
+
+// Serialize serializes a packet into a buffer of the form
+// <type|version><extension><connection_id><timestamp><timestamp_difference><wnd_size><seq_nr><ack_nr><payload>
+func (p *utpPacket) Serialize() []byte {
+	buf := make([]byte, utpHeaderLength+len(p.Payload))
+	buf[0] = p.Type<<4 | p.Version
+	buf[1] = 0 // No extensions
+	binary.BigEndian.PutUint16(buf[2:4], p.ConnectionID)
+	binary.BigEndian.PutUint32(buf[4:8], p.Timestamp)
+	binary.BigEndian.PutUint32(buf[8:12], p.TimestampDifference)
+	binary.BigEndian.PutUint32(buf[12:16], p.WindowSize)
+	binary.BigEndian.PutUint16(buf[16:18], p.SeqNr)
+	binary.BigEndian.PutUint16(buf[18:20], p.AckNr)
+	copy(buf[utpHeaderLength:], p.Payload)
+	return buf
+}
+
+func parseUTPPacket(buf []byte) (*utpPacket, error) {
+	if len(buf) < utpHeaderLength {
+		return nil, fmt.Errorf("packet is %d bytes long, expected at least %d bytes for the header", len(buf), utpHeaderLength)
+	}
+
+	p := utpPacket{
+		Type:                buf[0] >> 4,
+		Version:             buf[0] & 0x0f,
+		ConnectionID:        binary.BigEndian.Uint16(buf[2:4]),
+		Timestamp:           binary.BigEndian.Uint32(buf[4:8]),
+		TimestampDifference: binary.BigEndian.Uint32(buf[8:12]),
+		WindowSize:          binary.BigEndian.Uint32(buf[12:16]),
+		SeqNr:               binary.BigEndian.Uint16(buf[16:18]),
+		AckNr:               binary.BigEndian.Uint16(buf[18:20]),
+	}
+
+	if p.Version != utpVersion {
+		return nil, fmt.Errorf("expected version %d in the low 4 bits of the first byte, got %d", utpVersion, p.Version)
+	}
+	if p.Type > uint8(utpTypeSyn) {
+		return nil, fmt.Errorf("unknown packet type %d in the high 4 bits of the first byte", p.Type)
+	}
+
+	// Skip the extension chain: <next extension><length><payload>
+	offset := utpHeaderLength
+	for extension := buf[1]; extension != 0; {
+		if offset+2 > len(buf) {
+			return nil, errors.New("packet ends in the middle of an extension header")
+		}
+		extension = buf[offset]
+		length := int(buf[offset+1])
+		offset += 2 + length
+		if offset > len(buf) {
+			return nil, errors.New("extension length is larger than the packet")
+		}
+	}
+
+	p.Payload = buf[offset:]
+	return &p, nil
+}
+
+type utpTraceEntry struct {
+	at        time.Duration
+	direction string
+	packet    utpPacket
+}
+
+type utpInFlightPacket struct {
+	packet        *utpPacket
+	sentAt        time.Time
+	retransmitted bool
+}
+
+// utpAcceptQueueLength is how many connections can wait to be accepted, later ones are reset
+const utpAcceptQueueLength = 16
+
+type utpListener struct {
+	packetConn net.PacketConn
+	logger     *logger.Logger
+	servers    *serverLifecycle
+	startedAt  time.Time
+
+	mu          sync.Mutex
+	connections map[string]*utpConn
+	accepted    chan *utpConn
+	closed      chan struct{}
+}
+
+func listenUTP(address string, logger *logger.Logger) (*utpListener, error) {
+	udpConn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	packetConn := capturePacketConn(udpConn, logger)
+
+	l := &utpListener{
+		packetConn:  packetConn,
+		logger:      logger,
+		servers:     serverLifecycleFor(logger),
+		startedAt:   time.Now(),
+		connections: make(map[string]*utpConn),
+		accepted:    make(chan *utpConn, utpAcceptQueueLength),
+		closed:      make(chan struct{}),
+	}
+	l.servers.goServe(l.serve)
+	return l, nil
+}
+
+func (l *utpListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.accepted:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *utpListener) Close() error {
+	select {
+	case <-l.closed:
+		return nil
+	default:
+		close(l.closed)
+	}
+	err := l.packetConn.Close()
+
+	// Connections that were never accepted have no handler to close them
+	for {
+		select {
+		case conn := <-l.accepted:
+			conn.Close()
+		default:
+			return err
+		}
+	}
+}
+
+func (l *utpListener) Addr() net.Addr {
+	return l.packetConn.LocalAddr()
+}
+
+func (l *utpListener) now() uint32 {
+	return uint32(time.Since(l.startedAt).Microseconds())
+}
+
+func (l *utpListener) serve() {
+	buf := make([]byte, 65535)
+	for {
+		n, remote, err := l.packetConn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-l.closed:
+			default:
+				l.logger.Errorf("Error reading uTP packet: %s", err)
+			}
+			return
+		}
+
+		packet, err := parseUTPPacket(append([]byte{}, buf[:n]...))
+		if err != nil {
+			l.logger.Errorf("Received invalid uTP packet from %s: %s", remote, err)
+			continue
+		}
+
+		key := fmt.Sprintf("%s/%d", remote, packet.ConnectionID)
+		if packet.packetType() == utpTypeSyn {
+			key = fmt.Sprintf("%s/%d", remote, packet.ConnectionID+1)
+		}
+
+		l.mu.Lock()
+		conn, exists := l.connections[key]
+		// Only serve sends to accepted, so there's still room for the connection when it's sent below
+		isQueueFull := len(l.accepted) == cap(l.accepted)
+		if !exists && packet.packetType() == utpTypeSyn && !isQueueFull {
+			conn = newUTPConn(l, remote, packet)
+			l.connections[key] = conn
+		}
+		l.mu.Unlock()
+
+		if conn == nil && packet.packetType() == utpTypeSyn {
+			l.logger.Errorf("Too many uTP connections waiting to be accepted, resetting connection from %s", remote)
+			l.sendReset(packet, remote)
+			continue
+		}
+
+		if conn == nil {
+			l.logger.Debugf("Received uTP %s packet for unknown connection_id %d, sending ST_RESET", packet.packetType(), packet.ConnectionID)
+			l.sendReset(packet, remote)
+			continue
+		}
+
+		if !exists {
+			l.logger.Debugf("Accepted uTP connection from %s (connection_id: %d)", remote, packet.ConnectionID)
+			conn.trace(">>", packet)
+			conn.sendState()
+			l.accepted <- conn
+			continue
+		}
+
+		conn.handlePacket(packet)
+	}
+}
+
+// sendReset answers the packet with ST_RESET
+func (l *utpListener) sendReset(packet *utpPacket, remote net.Addr) {
+	reset := utpPacket{Type: uint8(utpTypeReset), Version: utpVersion, ConnectionID: packet.ConnectionID, Timestamp: l.now(), AckNr: packet.SeqNr}
+	l.packetConn.WriteTo(reset.Serialize(), remote)
+}
+
+func (l *utpListener) remove(c *utpConn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, conn := range l.connections {
+		if conn == c {
+			delete(l.connections, key)
+		}
+	}
+}
+
+// utpConn is a single uTP connection accepted by a utpListener
+type utpConn struct {
+	listener *utpListener
+	remote   net.Addr
+	recvID   uint16
+	sendID   uint16
+	logger   *logger.Logger
+
+	mu               sync.Mutex
+	changed          chan struct{}
+	done             chan struct{}
+	seqNr            uint16 // Sequence number of the next packet we send, ST_STATE packets carry it without using it up
+	ackNr            uint16
+	readBuffer       bytes.Buffer
+	outOfOrder       map[uint16]*utpPacket
+	inFlight         []*utpInFlightPacket
+	congestionWindow float64
+	peerWindow       uint32
+	baseDelay        uint32
+	replyMicro       uint32
+	rtt              time.Duration
+	rttVariance      time.Duration
+	timeout          time.Duration
+	eof              bool
+	reset            bool
+	closed           bool
+	waitingReaders   int
+	readDeadline     time.Time
+	writeDeadline    time.Time
+	lastReceivedAt   time.Time
+	stallReported    bool
+	traceEntries     []utpTraceEntry
+}
+
+func newUTPConn(l *utpListener, remote net.Addr, syn *utpPacket) *utpConn {
+	c := &utpConn{
+		listener:         l,
+		remote:           remote,
+		recvID:           syn.ConnectionID + 1,
+		sendID:           syn.ConnectionID,
+		logger:           l.logger,
+		changed:          make(chan struct{}),
+		done:             make(chan struct{}),
+		seqNr:            uint16(time.Now().UnixNano()),
+		ackNr:            syn.SeqNr,
+		outOfOrder:       make(map[uint16]*utpPacket),
+		congestionWindow: 2 * utpMaxPayloadLength,
+		peerWindow:       syn.WindowSize,
+		replyMicro:       l.now() - syn.Timestamp,
+		timeout:          time.Second,
+		lastReceivedAt:   time.Now(),
+	}
+	l.servers.goServe(c.monitor)
+	return c
+}
+
+// signal wakes up all goroutines blocked in Read, Write or Close. Callers must hold c.mu.
+func (c *utpConn) signal() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func waitForChange(changed <-chan struct{}, deadline time.Time) error {
+	if deadline.IsZero() {
+		<-changed
+		return nil
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-changed:
+		return nil
+	case <-timer.C:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// newPacket creates a packet with the current connection state. Callers must hold c.mu.
+func (c *utpConn) newPacket(packetType utpPacketType) *utpPacket {
+	return &utpPacket{
+		Type:         uint8(packetType),
+		Version:      utpVersion,
+		ConnectionID: c.sendID,
+		SeqNr:        c.seqNr,
+		AckNr:        c.ackNr,
+	}
+}
+
+func (c *utpConn) send(p *utpPacket) error {
+	c.mu.Lock()
+	p.Timestamp = c.listener.now()
+	p.TimestampDifference = c.replyMicro
+	p.WindowSize = uint32(max(utpReceiveWindow-c.readBuffer.Len(), 0))
+	p.AckNr = c.ackNr
+	c.traceLocked("<<", p)
+	c.mu.Unlock()
+
+	_, err := c.listener.packetConn.WriteTo(p.Serialize(), c.remote)
+	return err
+}
+
+func (c *utpConn) sendState() {
+	c.mu.Lock()
+	state := c.newPacket(utpTypeState)
+	c.mu.Unlock()
+	c.send(state)
+}
+
+func (c *utpConn) trace(direction string, p *utpPacket) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.traceLocked(direction, p)
+}
+
+func (c *utpConn) traceLocked(direction string, p *utpPacket) {
+	entry := utpTraceEntry{at: time.Since(c.listener.startedAt), direction: direction, packet: *p}
+	c.traceEntries = append(c.traceEntries, entry)
+	if len(c.traceEntries) > utpTraceLength {
+		c.traceEntries = c.traceEntries[1:]
+	}
+}
+
+func (c *utpConn) handlePacket(p *utpPacket) {
+	c.mu.Lock()
+	c.traceLocked(">>", p)
+	c.lastReceivedAt = time.Now()
+	c.stallReported = false
+	c.replyMicro = c.listener.now() - p.Timestamp
+	c.peerWindow = p.WindowSize
+
+	shouldAck := false
+	switch p.packetType() {
+	case utpTypeReset:
+		c.logger.Debugln("Received uTP ST_RESET, closing connection")
+		c.reset = true
+		c.eof = true
+	case utpTypeSyn:
+		// Our ST_STATE reply was probably lost
+		shouldAck = true
+	case utpTypeState:
+		c.processAck(p)
+	case utpTypeData, utpTypeFin:
+		c.processAck(p)
+		c.receive(p)
+		shouldAck = true
+	}
+	c.signal()
+	c.mu.Unlock()
+
+	if shouldAck {
+		c.sendState()
+	}
+}
+
+// receive delivers in-order DATA and FIN packets. Callers must hold c.mu.
+func (c *utpConn) receive(p *utpPacket) {
+	if int16(p.SeqNr-c.ackNr) <= 0 {
+		return // Duplicate
+	}
+	c.outOfOrder[p.SeqNr] = p
+
+	for {
+		next, exists := c.outOfOrder[c.ackNr+1]
+		if !exists {
+			return
+		}
+		delete(c.outOfOrder, c.ackNr+1)
+		c.ackNr++
+		if next.packetType() == utpTypeFin {
+			c.eof = true
+			return
+		}
+		c.readBuffer.Write(next.Payload)
+	}
+}
+
+// processAck removes acknowledged packets and updates the congestion window. Callers must hold c.mu.
+func (c *utpConn) processAck(p *utpPacket) {
+	bytesAcked := 0
+	remaining := c.inFlight[:0]
+	for _, inFlight := range c.inFlight {
+		if int16(p.AckNr-inFlight.packet.SeqNr) < 0 {
+			remaining = append(remaining, inFlight)
+			continue
+		}
+		bytesAcked += len(inFlight.packet.Payload)
+		if !inFlight.retransmitted {
+			c.updateRTT(time.Since(inFlight.sentAt))
+		}
+	}
+	c.inFlight = remaining
+
+	if bytesAcked == 0 {
+		return
+	}
+
+	// LEDBAT: grow the window while the measured one-way delay is below target, shrink it above
+	delay := p.TimestampDifference
+	if c.baseDelay == 0 || delay < c.baseDelay {
+		c.baseDelay = delay
+	}
+	offTarget := float64(utpTargetDelayMicroseconds-int64(delay-c.baseDelay)) / utpTargetDelayMicroseconds
+	c.congestionWindow += utpMaxWindowIncrease * offTarget * float64(bytesAcked) / c.congestionWindow
+	c.congestionWindow = min(max(c.congestionWindow, utpMaxPayloadLength), utpReceiveWindow)
+}
+
+// updateRTT follows the timeout calculation from BEP 29. Callers must hold c.mu.
+func (c *utpConn) updateRTT(sample time.Duration) {
+	if c.rtt == 0 {
+		c.rtt = sample
+		c.rttVariance = sample / 2
+	} else {
+		delta := c.rtt - sample
+		if delta < 0 {
+			delta = -delta
+		}
+		c.rttVariance += (delta - c.rttVariance) / 4
+		c.rtt += (sample - c.rtt) / 8
+	}
+	c.timeout = max(c.rtt+4*c.rttVariance, utpMinTimeout)
+}
+
+// inFlightBytes returns the number of unacknowledged payload bytes. Callers must hold c.mu.
+func (c *utpConn) inFlightBytes() int {
+	total := 0
+	for _, inFlight := range c.inFlight {
+		total += len(inFlight.packet.Payload)
+	}
+	return total
+}
+
+func (c *utpConn) monitor() {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+		var retransmit *utpPacket
+		if len(c.inFlight) > 0 && time.Since(c.inFlight[0].sentAt) > c.timeout {
+			oldest := c.inFlight[0]
+			oldest.sentAt = time.Now()
+			oldest.retransmitted = true
+			retransmit = oldest.packet
+			c.congestionWindow = utpMaxPayloadLength
+			c.timeout = min(2*c.timeout, 8*time.Second)
+		}
+
+		isWaiting := len(c.inFlight) > 0 || c.waitingReaders > 0
+		if isWaiting && !c.stallReported && time.Since(c.lastReceivedAt) > utpStallTimeout {
+			c.stallReported = true
+			c.logTraceLocked(fmt.Sprintf("uTP connection stalled, no packets received for %s", utpStallTimeout))
+		}
+		c.mu.Unlock()
+
+		if retransmit != nil {
+			c.logger.Debugf("uTP packet seq_nr=%d timed out, retransmitting", retransmit.SeqNr)
+			c.send(retransmit)
+		}
+	}
+}
+
+// logTraceLocked prints the most recent packets sent and received. Callers must hold c.mu.
+func (c *utpConn) logTraceLocked(reason string) {
+	c.logger.Errorln(reason)
+	c.logger.Infof("Last %d uTP packets (>> received, << sent), state: seq_nr=%d ack_nr=%d cwnd=%d in_flight=%d", len(c.traceEntries), c.seqNr, c.ackNr, int(c.congestionWindow), c.inFlightBytes())
+	for _, entry := range c.traceEntries {
+		p := entry.packet
+		c.logger.Infof("%10.3fms %s %-8s conn_id=%-5d seq_nr=%-5d ack_nr=%-5d wnd=%-7d len=%d", float64(entry.at.Microseconds())/1000, entry.direction, p.packetType(), p.ConnectionID, p.SeqNr, p.AckNr, p.WindowSize, len(p.Payload))
+	}
+}
+
+func (c *utpConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.readBuffer.Len() == 0 {
+		if c.eof {
+			return 0, io.EOF
+		}
+		if c.closed {
+			return 0, net.ErrClosed
+		}
+
+		changed, deadline := c.changed, c.readDeadline
+		c.waitingReaders++
+		c.mu.Unlock()
+		err := waitForChange(changed, deadline)
+		c.mu.Lock()
+		c.waitingReaders--
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return c.readBuffer.Read(b)
+}
+
+func (c *utpConn) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		chunkLength := min(len(b)-written, utpMaxPayloadLength)
+
+		c.mu.Lock()
+		for !c.closed && !c.reset && len(c.inFlight) > 0 && c.inFlightBytes()+chunkLength > int(min(c.congestionWindow, float64(c.peerWindow))) {
+			changed, deadline := c.changed, c.writeDeadline
+			c.mu.Unlock()
+			if err := waitForChange(changed, deadline); err != nil {
+				return written, err
+			}
+			c.mu.Lock()
+		}
+		if c.closed || c.reset {
+			c.mu.Unlock()
+			return written, net.ErrClosed
+		}
+
+		packet := c.newPacket(utpTypeData)
+		c.seqNr++
+		packet.Payload = append([]byte{}, b[written:written+chunkLength]...)
+		c.inFlight = append(c.inFlight, &utpInFlightPacket{packet: packet, sentAt: time.Now()})
+		c.mu.Unlock()
+
+		if err := c.send(packet); err != nil {
+			return written, err
+		}
+		written += chunkLength
+	}
+
+	return written, nil
+}
+
+func (c *utpConn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+
+	// Give outstanding data a chance to be acknowledged before sending ST_FIN
+	deadline := time.Now().Add(utpCloseTimeout)
+	for len(c.inFlight) > 0 && !c.reset && time.Now().Before(deadline) {
+		changed := c.changed
+		c.mu.Unlock()
+		waitForChange(changed, deadline)
+		c.mu.Lock()
+	}
+
+	c.closed = true
+	fin := c.newPacket(utpTypeFin)
+	c.seqNr++
+	isReset := c.reset
+	c.signal()
+	c.mu.Unlock()
+
+	if !isReset {
+		c.send(fin)
+	}
+	close(c.done)
+	c.listener.remove(c)
+	return nil
+}
+
+func (c *utpConn) LocalAddr() net.Addr {
+	return c.listener.Addr()
+}
+
+func (c *utpConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *utpConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *utpConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	c.signal()
+	return nil
+}
+
+func (c *utpConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	c.signal()
+	return nil
+}
+
+// startUTPPeer listens for uTP connections on the peer address and handles them until the test case ends
+func startUTPPeer(p PeerConnectionParams, handler ConnectionHandler) {
+	logger := p.logger
+	logger.Debugf("Peer listening for uTP on address: %s", p.address)
+	utpListener, err := listenUTP(p.address, logger)
+	if err != nil {
+		logger.Errorf("Error: %s", err)
+		return
+	}
+
+	servers := serverLifecycleFor(logger)
+	release := servers.track(utpListener, "uTP peer on "+p.address)
+
+	listener, err := withFaults(utpListener, p.faultProfile, logger)
+	if err != nil {
+		release()
+		logger.Errorf("Error: %s", err)
+		return
+	}
+
+	servers.goServe(func() {
+		defer release()
+		acceptPeerConnections(meterListener(listener, logger), p, handler)
+	})
+}
@@ -0,0 +1,202 @@
+package internal
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/tester-utils/logger"
+)
+
+func TestUTPPacketRoundTrip(t *testing.T) {
+	packet := utpPacket{Type: uint8(utpTypeData), Version: utpVersion, ConnectionID: 7, Timestamp: 1, TimestampDifference: 2, WindowSize: 3, SeqNr: 4, AckNr: 5, Payload: []byte("abc")}
+	serialized := packet.Serialize()
+
+	// Insert a selective ack extension, which must be skipped
+	withExtension := append([]byte{}, serialized[:utpHeaderLength]...)
+	withExtension[1] = 1
+	withExtension = append(withExtension, 0, 4, 0xff, 0xff, 0xff, 0xff)
+	withExtension = append(withExtension, packet.Payload...)
+
+	for _, buf := range [][]byte{serialized, withExtension} {
+		parsed, err := parseUTPPacket(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed.packetType() != utpTypeData || parsed.ConnectionID != 7 || parsed.SeqNr != 4 || parsed.AckNr != 5 || string(parsed.Payload) != "abc" {
+			t.Fatalf("unexpected packet: %+v", parsed)
+		}
+	}
+
+	if _, err := parseUTPPacket([]byte{0x42}); err == nil {
+		t.Fatal("expected short packet to be rejected")
+	}
+}
+
+func TestUTPConnectionExchange(t *testing.T) {
+	listener, err := listenUTP("127.0.0.1:0", logger.GetQuietLogger(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	client, err := net.Dial("udp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	readPacket := func() *utpPacket {
+		buf := make([]byte, 65535)
+		n, err := client.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := parseUTPPacket(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	syn := utpPacket{Type: uint8(utpTypeSyn), Version: utpVersion, ConnectionID: 100, SeqNr: 1, WindowSize: utpReceiveWindow}
+	client.Write(syn.Serialize())
+
+	state := readPacket()
+	if state.packetType() != utpTypeState || state.ConnectionID != 100 || state.AckNr != 1 {
+		t.Fatalf("expected ST_STATE acknowledging the ST_SYN, got %s %+v", state.packetType(), state)
+	}
+
+	response := bytes.Repeat([]byte("x"), 3*utpMaxPayloadLength)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		request := make([]byte, 5)
+		io.ReadFull(conn, request)
+		conn.Write(append(request, response...))
+		conn.Close()
+	}()
+
+	data := utpPacket{Type: uint8(utpTypeData), Version: utpVersion, ConnectionID: 101, SeqNr: 2, AckNr: state.SeqNr, WindowSize: utpReceiveWindow, Payload: []byte("hello")}
+	client.Write(data.Serialize())
+
+	var received bytes.Buffer
+	for {
+		p := readPacket()
+		if p.packetType() == utpTypeState {
+			continue
+		}
+		ack := utpPacket{Type: uint8(utpTypeState), Version: utpVersion, ConnectionID: 101, SeqNr: 3, AckNr: p.SeqNr, WindowSize: utpReceiveWindow}
+		client.Write(ack.Serialize())
+		if p.packetType() == utpTypeFin {
+			break
+		}
+		received.Write(p.Payload)
+	}
+
+	if !bytes.Equal(received.Bytes(), append([]byte("hello"), response...)) {
+		t.Fatalf("unexpected data received: %d bytes", received.Len())
+	}
+}
+
+// Clients like libutp set ack_nr to one less than the ST_STATE seq_nr, so the first ST_DATA has to use that seq_nr
+func TestUTPFirstDataPacketUsesStateSeqNr(t *testing.T) {
+	listener, err := listenUTP("127.0.0.1:0", logger.GetQuietLogger(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	client, err := net.Dial("udp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	readPacket := func() *utpPacket {
+		buf := make([]byte, 65535)
+		n, err := client.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := parseUTPPacket(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	syn := utpPacket{Type: uint8(utpTypeSyn), Version: utpVersion, ConnectionID: 100, SeqNr: 1, WindowSize: utpReceiveWindow}
+	client.Write(syn.Serialize())
+	state := readPacket()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("first"))
+		conn.Write([]byte("second"))
+	}()
+
+	for _, offset := range []uint16{0, 1} {
+		data := readPacket()
+		if data.packetType() != utpTypeData {
+			t.Fatalf("expected ST_DATA, got %s", data.packetType())
+		}
+		if data.SeqNr != state.SeqNr+offset {
+			t.Fatalf("expected ST_DATA with seq_nr %d after ST_STATE with seq_nr %d, got %d", state.SeqNr+offset, state.SeqNr, data.SeqNr)
+		}
+	}
+}
+
+func TestUTPListenerResetsConnectionsWhenQueueIsFull(t *testing.T) {
+	context, logger := registerTestCaseContext(t)
+	listener, err := listenUTP("127.0.0.1:0", logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := context.servers.track(listener, "uTP peer")
+
+	client, err := net.Dial("udp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	buf := make([]byte, 65535)
+	for i := range utpAcceptQueueLength + 1 {
+		syn := utpPacket{Type: uint8(utpTypeSyn), Version: utpVersion, ConnectionID: uint16(100 + 2*i), SeqNr: 1, WindowSize: utpReceiveWindow}
+		client.Write(syn.Serialize())
+
+		n, err := client.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply, err := parseUTPPacket(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := utpTypeState
+		if i == utpAcceptQueueLength {
+			expected = utpTypeReset
+		}
+		if reply.packetType() != expected {
+			t.Fatalf("expected %s in reply to connection %d, got %s", expected, i+1, reply.packetType())
+		}
+	}
+
+	// The connections that were never accepted have to stop with the listener
+	release()
+	context.cancel()
+	if err := context.servers.stop(time.Second); err != nil {
+		t.Fatalf("expected the listener and its connections to stop: %v", err)
+	}
+}